@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// rerunBaseWorkflows reruns the runs of the workflows named in workflowNames on the head of pr's base branch,
+// so their results can be compared with pr's. Runs that have not completed are left alone. Workflows are
+// skipped like those of pr's runs if outside of h.workflowPaths, inactive, or on cooldown.
+func (h *handler) rerunBaseWorkflows(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest,
+	workflowNames map[string]rerunOptions) ([]rerunResult, error) {
+	baseRef := pr.GetBase().GetRef()
+	branch, _, err := h.Repositories.GetBranch(ctx, repoOwner, repoName, baseRef)
+	if err != nil {
+		return nil, fmt.Errorf("get base branch: %v", err)
+	}
+	baseSHA := branch.GetCommit().GetSHA()
+	allWorkflows, _, err := h.Actions.ListWorkflows(ctx, repoOwner, repoName, &github.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list workflows: %v", err)
+	}
+	h.warnUnmatchedWorkflows(workflowNames, allWorkflows.Workflows)
+
+	var results []rerunResult
+	for _, workflow := range allWorkflows.Workflows {
+		opts, named := workflowNames[workflow.GetName()]
+		if !named {
+			continue
+		}
+		if h.isSelfWorkflow(workflow) {
+			h.Debugf("Skipping the workflow containing this job")
+			continue
+		}
+		if !h.isWorkflowPathAllowed(workflow.GetPath()) {
+			h.Warningf("Workflow %s path %s is not in workflow_paths, will not rerun", workflow.GetName(), workflow.GetPath())
+			continue
+		}
+		if workflow.GetState() != activeState {
+			h.Debugf("Skipping inactive workflow")
+			continue
+		}
+		run, err := h.findBranchRun(ctx, repoOwner, repoName, workflow.GetID(), baseRef, baseSHA)
+		if err != nil {
+			return nil, fmt.Errorf("list workflow runs: %v", err)
+		}
+		if run == nil {
+			h.Warningf("No run of workflow %s found for %s head %s", workflow.GetName(), baseRef, baseSHA)
+			continue
+		}
+		result := rerunResult{
+			prNum:        pr.GetNumber(),
+			workflowName: fmt.Sprintf("%s (%s)", workflow.GetName(), baseRef),
+			cooldownName: workflow.GetName(),
+			run:          run,
+			selections:   []string{selectionBaseHead},
+		}
+		if end, onCooldown := h.cooldownEnd(workflow.GetName()); onCooldown {
+			h.Warningf("Workflow %s is on cooldown until %s, will not rerun", workflow.GetName(), end.Format(time.RFC3339))
+			result.outcome = fmt.Sprintf(outcomeSkippedCooldown, end.Format(time.RFC3339))
+		} else if run.GetStatus() != completedStatus {
+			h.Debugf("Workflow run %d is %s, will not cancel", run.GetID(), run.GetStatus())
+			result.outcome = outcomeSkippedIncomplete
+		} else if _, err := h.rerun(ctx, repoOwner, repoName, run.GetID(), opts); isPermissionDenied(err) {
+			h.Errorf("Failed to rerun workflow: %v", err)
+			result.outcome, result.err = outcomePermissionDenied, describeError(err)
+		} else if err != nil {
+			h.Errorf("Failed to rerun workflow: %v", err)
+			result.outcome, result.err = outcomeRerunFailed, describeError(err)
+		} else {
+			h.Debugf("Rerunning %s run %d", baseRef, run.GetID())
+			result.outcome = outcomeRerun
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// findBranchRun returns the workflow's latest run for branch's head SHA among the first page of its runs
+// on branch. The head's runs are the newest, so older pages are not searched. A nil run is returned if
+// none match.
+func (h *handler) findBranchRun(ctx context.Context, repoOwner, repoName string, workflowID int64, branch, sha string) (*github.WorkflowRun, error) {
+	opts := &github.ListWorkflowRunsOptions{
+		Branch:      branch,
+		ListOptions: github.ListOptions{PerPage: h.runsPerPage},
+	}
+	runs, _, err := h.Actions.ListWorkflowRunsByID(ctx, repoOwner, repoName, workflowID, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs.WorkflowRuns {
+		if run.GetHeadSHA() == sha && run.GetWorkflowID() == workflowID {
+			return run, nil
+		}
+	}
+	return nil, nil
+}
+
+// splitBaseWorkflows removes workflows selected by the rerun-base command from testsToRerun, returning them.
+func splitBaseWorkflows(testsToRerun map[string]rerunOptions) map[string]rerunOptions {
+	baseWorkflows := make(map[string]rerunOptions)
+	for name, opts := range testsToRerun {
+		if strings.HasPrefix(name, testBasePrefix) {
+			baseWorkflows[strings.TrimPrefix(name, testBasePrefix)] = opts
+			delete(testsToRerun, name)
+		}
+	}
+	return baseWorkflows
+}
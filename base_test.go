@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+)
+
+func TestSplitBaseWorkflows(t *testing.T) {
+	testsToRerun := map[string]rerunOptions{
+		testAll:                  {},
+		testBasePrefix + "build": {failedJobsOnly: true},
+	}
+	base := splitBaseWorkflows(testsToRerun)
+	if want := map[string]rerunOptions{"build": {failedJobsOnly: true}}; !reflect.DeepEqual(base, want) {
+		t.Errorf("got base workflows %+v, want %+v", base, want)
+	}
+	if want := map[string]rerunOptions{testAll: {}}; !reflect.DeepEqual(testsToRerun, want) {
+		t.Errorf("got remaining %+v, want %+v", testsToRerun, want)
+	}
+}
+
+func TestRerunBaseWorkflows(t *testing.T) {
+	build, lint, docs, e2e := testWorkflow(1, "build"), testWorkflow(2, "lint"), testWorkflow(3, "docs"), testWorkflow(4, "e2e")
+	docs.State = github.String(disabledManuallyState)
+	e2e.Path = github.String(".github/workflows/nightly/e2e.yaml")
+	workflows := []*github.Workflow{build, lint, docs, e2e}
+	runs := make(map[int64][]*github.WorkflowRun, len(workflows))
+	for _, workflow := range workflows {
+		runs[workflow.GetID()] = []*github.WorkflowRun{testRun(workflow.GetID()*10, workflow.GetID(), "basesha", failureConclusion)}
+	}
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/branches/main", http.StatusOK,
+		&github.Branch{Name: github.String("main"), Commit: &github.RepositoryCommit{SHA: github.String("basesha")}})
+	api.handleWorkflows(workflows, runs)
+	for _, workflow := range workflows {
+		api.handle(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", workflow.GetID()*10), http.StatusCreated, nil)
+	}
+	h := newTestHandler(t, api)
+	h.workflowPaths = []string{".github/workflows/b", ".github/workflows/l", ".github/workflows/d"}
+	h.workflowCooldowns = map[string]time.Duration{"lint": time.Hour}
+	h.rerunTimes = rerunTimes{"lint": time.Now()}
+
+	names := map[string]rerunOptions{"build": {}, "lint": {}, "docs": {}, "e2e": {}}
+	results, err := h.rerunBaseWorkflows(context.Background(), testOwner, testRepo, testPR(), names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string, len(results))
+	for _, result := range results {
+		got[result.workflowName] = result.outcome
+	}
+	if len(got) != 2 || got["build (main)"] != outcomeRerun || !strings.HasPrefix(got["lint (main)"], "skipped, on cooldown") {
+		t.Errorf("got outcomes %v, want build rerun and lint on cooldown", got)
+	}
+	for id, want := range map[int64]bool{10: true, 20: false, 30: false, 40: false} {
+		if rerun := api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id)); rerun != want {
+			t.Errorf("run %d rerun: got %t, want %t", id, rerun, want)
+		}
+	}
+}
+
+func TestRerunBaseWorkflowsCooldown(t *testing.T) {
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/branches/main", http.StatusOK,
+		&github.Branch{Name: github.String("main"), Commit: &github.RepositoryCommit{SHA: github.String("basesha")}})
+	api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+		map[int64][]*github.WorkflowRun{1: {testRun(10, 1, "basesha", failureConclusion)}})
+	api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/rerun", http.StatusCreated, nil)
+	h := newTestHandler(t, api)
+	h.workflowCooldowns = map[string]time.Duration{"build": time.Hour}
+	h.rerunTimes = rerunTimes{}
+
+	names := map[string]rerunOptions{"build": {}}
+	results, err := h.rerunBaseWorkflows(context.Background(), testOwner, testRepo, testPR(), names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].outcome != outcomeRerun {
+		t.Fatalf("first: got results %+v, want build rerun", results)
+	}
+	h.recordRerunTimes(results)
+	if _, recorded := h.rerunTimes["build"]; !recorded {
+		t.Fatalf("got rerun times %v, want build recorded", h.rerunTimes)
+	}
+
+	if results, err = h.rerunBaseWorkflows(context.Background(), testOwner, testRepo, testPR(), names); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.HasPrefix(results[0].outcome, "skipped, on cooldown") {
+		t.Errorf("second: got results %+v, want build on cooldown", results)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// knownCommands are the names of recognized commands.
+var knownCommands = map[string]struct{}{
+	retestAllWorkflowsCommand: {},
+	testWorkflowCommand:       {},
+	rerunStackCommand:         {},
+	rerunAndMergeCommand:      {},
+	rerunGroupCommand:         {},
+	removeOkToTestCommand:     {},
+	rerunCheckCommand:         {},
+	rerunBaseCommand:          {},
+	rerunTagCommand:           {},
+	rerunSkippedCommand:       {},
+	rerunStaleCommand:         {},
+}
+
+// command is a recognized command parsed from a comment line.
+type command struct {
+	Name string   `json:"command"`
+	Args []string `json:"args"`
+	// Reason holds the hashtags following the command, ex. "#flaky", tagging why it was issued.
+	Reason string `json:"reason,omitempty"`
+}
+
+// commandParser parses commands from comment bodies.
+type commandParser struct {
+	// mention is an @-mention, ex. of a bot, that may prefix or follow commands.
+	mention string
+	// requireMention ignores comments that do not contain mention.
+	requireMention bool
+	// regex, if set, matches command lines, capturing the command name and its arguments
+	// in the groups named by commandRegexCommandGroup and commandRegexArgsGroup.
+	regex *regexp.Regexp
+}
+
+// Names of command_regex capture groups. The args group is optional.
+const (
+	commandRegexCommandGroup = "command"
+	commandRegexArgsGroup    = "args"
+)
+
+// rerunOptions configures how runs matched by a command are rerun.
+type rerunOptions struct {
+	// failedJobsOnly reruns only a run's failed jobs instead of all of its jobs.
+	failedJobsOnly bool
+	// skipIncomplete leaves runs that have not completed alone instead of cancelling and rerunning them.
+	skipIncomplete bool
+	// dispatchSkipped dispatches workflows whose runs were skipped, ex. by path filters, since those cannot be rerun
+	// to any effect.
+	dispatchSkipped bool
+	// conclusions, if set, limits reruns to completed runs with one of these conclusions.
+	conclusions map[string]struct{}
+}
+
+// setCommandsOutput sets the "commands" output to commands encoded as a JSON array.
+func (h *handler) setCommandsOutput(commands []command) {
+	if commands == nil {
+		commands = []command{}
+	}
+	b, err := json.Marshal(commands)
+	if err != nil {
+		h.Errorf("Failed to encode commands output: %v", err)
+		return
+	}
+	h.SetOutput("commands", string(b))
+}
+
+// optionsForWorkflow returns the options of the command that matched workflowName,
+// preferring a command naming the workflow over rerun-all.
+func optionsForWorkflow(testsToRerun map[string]rerunOptions, workflowName string) rerunOptions {
+	if opts, hasWorkflow := testsToRerun[workflowName]; hasWorkflow {
+		return opts
+	}
+	return testsToRerun[testAll]
+}
+
+// parseCommands parses one command per line of commentBody. Lines with unrecognized commands are skipped,
+// and no commands are returned if any line is not a command. Lines containing only p.mention are ignored.
+func (p commandParser) parseCommands(commentBody string) (commands []command) {
+	// Comments by this action, and users quoting them, may list commands that were already run.
+	if strings.Contains(commentBody, botCommentMarker) {
+		return nil
+	}
+	hasMention := false
+	scanner := bufio.NewScanner(strings.NewReader(commentBody))
+	for scanner.Scan() {
+		var splitComment []string
+		for _, word := range strings.Split(scanner.Text(), " ") {
+			if word = strings.TrimSpace(word); word == "" {
+				continue
+			}
+			if p.mention != "" && strings.EqualFold(word, p.mention) {
+				hasMention = true
+				continue
+			}
+			splitComment = append(splitComment, word)
+		}
+		if len(splitComment) == 0 && hasMention {
+			continue
+		}
+		if len(splitComment) == 0 {
+			return nil
+		}
+		name, words, isCommand := p.splitCommand(splitComment)
+		// Ignore non-command comments.
+		if !isCommand {
+			return nil
+		}
+		if isKnownCommand(name) {
+			args, reason := splitReason(words)
+			commands = append(commands, command{Name: name, Args: args, Reason: reason})
+		}
+	}
+	if p.requireMention && !hasMention {
+		return nil
+	}
+	return commands
+}
+
+// splitCommand splits a line's words into a command name and its words, using p.regex if set.
+// isCommand is false if the line is not a command.
+func (p commandParser) splitCommand(splitComment []string) (name string, words []string, isCommand bool) {
+	if p.regex != nil {
+		match := p.regex.FindStringSubmatch(strings.Join(splitComment, " "))
+		if match == nil {
+			return "", nil, false
+		}
+		if i := p.regex.SubexpIndex(commandRegexArgsGroup); i >= 0 {
+			words = strings.Fields(match[i])
+		}
+		return match[p.regex.SubexpIndex(commandRegexCommandGroup)], words, true
+	}
+	// Ignore words smaller than any command size.
+	if len(splitComment[0]) < 5 || splitComment[0][0] != '/' {
+		return "", nil, false
+	}
+	return splitComment[0][1:], splitComment[1:], true
+}
+
+// splitReason separates hashtags, ex. "#flaky", from a command's other words so they are not mistaken
+// for workflow names.
+func splitReason(words []string) (args []string, reason string) {
+	args = make([]string, 0, len(words))
+	var tags []string
+	for _, word := range words {
+		if len(word) > 1 && word[0] == '#' {
+			tags = append(tags, word)
+		} else {
+			args = append(args, word)
+		}
+	}
+	return args, strings.Join(tags, " ")
+}
+
+// commandReasons returns the distinct reasons given for commands.
+func commandReasons(commands []command) (reasons []string) {
+	seen := make(map[string]struct{})
+	for _, cmd := range commands {
+		if _, isSeen := seen[cmd.Reason]; cmd.Reason != "" && !isSeen {
+			seen[cmd.Reason] = struct{}{}
+			reasons = append(reasons, cmd.Reason)
+		}
+	}
+	return reasons
+}
+
+// isKnownCommand returns true if name is the name of a recognized command.
+func isKnownCommand(name string) bool {
+	_, isKnown := knownCommands[name]
+	return isKnown
+}
+
+// authorizeCommands splits commands into those authorAssoc meets the minimum association configured for
+// in h.commandAssociations, and those it does not. Commands without a configured association are allowed.
+func (h *handler) authorizeCommands(commands []command, authorAssoc string) (allowed, rejected []command) {
+	for _, cmd := range commands {
+		if minAssoc, hasMin := h.commandAssociations[cmd.Name]; hasMin && !hasMinAssociation(authorAssoc, minAssoc) {
+			rejected = append(rejected, cmd)
+			continue
+		}
+		allowed = append(allowed, cmd)
+	}
+	return allowed, rejected
+}
+
+// reportRejectedCommands tells the commenter which commands their association does not allow,
+// so the commands are not mistaken for having been ignored.
+func (h *handler) reportRejectedCommands(ctx context.Context, repoOwner, repoName string, prNum int,
+	rejected []command, authorAssoc string) {
+	sb := &strings.Builder{}
+	sb.WriteString("The following commands were not run because they require a more trusted author association:\n\n")
+	for _, cmd := range rejected {
+		fmt.Fprintf(sb, "- `/%s` requires `%s` (commenter is `%s`)\n",
+			cmd.Name, h.commandAssociations[cmd.Name], strings.ToLower(authorAssoc))
+	}
+	h.Warningf("%s", sb.String())
+	if h.postSummary {
+		if err := h.createComment(ctx, repoOwner, repoName, prNum, sb.String()); err != nil {
+			h.Errorf("Failed to comment rejected commands: %v", err)
+		}
+	}
+}
+
+// commandsToWorkflowNames maps the workflow names selected by commands to the options for rerunning them.
+// Commands' selections are combined as a union. A target selected by several commands is rerun as the
+// least restrictive of them asks, see mergeOptions.
+func commandsToWorkflowNames(commands []command) map[string]rerunOptions {
+	testsToRerun := make(map[string]rerunOptions)
+	for _, cmd := range commands {
+		args, opts := parseCommandArgs(cmd.Args)
+		switch cmd.Name {
+		case retestAllWorkflowsCommand:
+			addTarget(testsToRerun, testAll, opts)
+		case testWorkflowCommand:
+			if len(args) < 1 {
+				continue
+			}
+			addTarget(testsToRerun, args[0], opts)
+		case rerunSkippedCommand:
+			if len(args) < 1 {
+				continue
+			}
+			opts.dispatchSkipped = true
+			addTarget(testsToRerun, args[0], opts)
+		case rerunStaleCommand:
+			addTarget(testsToRerun, testStale, opts)
+		case rerunStackCommand:
+			addTarget(testsToRerun, testStack, opts)
+		case rerunAndMergeCommand:
+			addTarget(testsToRerun, testMerge, opts)
+		case rerunCheckCommand:
+			if len(args) < 1 {
+				continue
+			}
+			// Check names, unlike workflow names, often contain spaces.
+			addTarget(testsToRerun, testCheckPrefix+strings.Join(args, " "), opts)
+		case rerunBaseCommand:
+			if len(args) < 1 {
+				continue
+			}
+			addTarget(testsToRerun, testBasePrefix+args[0], opts)
+		case removeOkToTestCommand:
+			addTarget(testsToRerun, testRemoveLabel, opts)
+		case rerunGroupCommand:
+			if len(args) < 1 {
+				continue
+			}
+			// Groups rerun only failed runs, so in-progress members are left alone.
+			opts.skipIncomplete = true
+			addTarget(testsToRerun, testGroupPrefix+args[0], opts)
+		case rerunTagCommand:
+			if len(args) < 1 {
+				continue
+			}
+			addTarget(testsToRerun, testTagPrefix+args[0], opts)
+		}
+	}
+	return testsToRerun
+}
+
+// addTarget selects target in testsToRerun, merging opts with those of any command that already selected it.
+func addTarget(testsToRerun map[string]rerunOptions, target string, opts rerunOptions) {
+	if prevOpts, selected := testsToRerun[target]; selected {
+		opts = mergeOptions(prevOpts, opts)
+	}
+	testsToRerun[target] = opts
+}
+
+// mergeOptions combines the options of two commands selecting the same target. A restriction, ex. rerunning
+// only failed jobs, applies only if both commands ask for it, so neither command reruns less than it asked for.
+func mergeOptions(a, b rerunOptions) rerunOptions {
+	merged := rerunOptions{
+		failedJobsOnly: a.failedJobsOnly && b.failedJobsOnly,
+		skipIncomplete: a.skipIncomplete && b.skipIncomplete,
+		// Dispatching adds to what a rerun does, so it is not a restriction.
+		dispatchSkipped: a.dispatchSkipped || b.dispatchSkipped,
+	}
+	if a.conclusions != nil && b.conclusions != nil {
+		merged.conclusions = make(map[string]struct{}, len(a.conclusions)+len(b.conclusions))
+		for _, conclusions := range []map[string]struct{}{a.conclusions, b.conclusions} {
+			for conclusion := range conclusions {
+				merged.conclusions[conclusion] = struct{}{}
+			}
+		}
+	}
+	return merged
+}
+
+// parseConclusions parses a comma-separated list of run conclusions, ex. "failure,cancelled".
+// Nil is returned for an empty list, so any conclusion is rerun.
+func parseConclusions(list string) map[string]struct{} {
+	var conclusions map[string]struct{}
+	for _, conclusion := range strings.Split(list, ",") {
+		if conclusion = strings.ToLower(strings.TrimSpace(conclusion)); conclusion == "" {
+			continue
+		}
+		if conclusions == nil {
+			conclusions = make(map[string]struct{})
+		}
+		conclusions[conclusion] = struct{}{}
+	}
+	return conclusions
+}
+
+// parseCommandArgs separates flags from positional arguments of a command.
+// Unrecognized flags are ignored.
+func parseCommandArgs(words []string) (args []string, opts rerunOptions) {
+	for i := 0; i < len(words); i++ {
+		switch word := words[i]; {
+		case word == failedJobsOnlyFlag:
+			opts.failedJobsOnly = true
+		case word == conclusionsFlag && i+1 < len(words):
+			// The flag's value may follow it, ex. "--conclusions failure,cancelled".
+			i++
+			opts.conclusions = parseConclusions(words[i])
+		case strings.HasPrefix(word, conclusionsFlag+"="):
+			opts.conclusions = parseConclusions(strings.TrimPrefix(word, conclusionsFlag+"="))
+		case strings.HasPrefix(word, "--"):
+		default:
+			args = append(args, word)
+		}
+	}
+	return args, opts
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestParseCommandArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		words    []string
+		wantArgs []string
+		wantOpts rerunOptions
+	}{
+		{name: "none"},
+		{name: "args", words: []string{"build", "lint"}, wantArgs: []string{"build", "lint"}},
+		{name: "failed jobs only", words: []string{"--failed-jobs-only"}, wantOpts: rerunOptions{failedJobsOnly: true}},
+		{
+			name:     "flag after arg",
+			words:    []string{"build", "--failed-jobs-only"},
+			wantArgs: []string{"build"},
+			wantOpts: rerunOptions{failedJobsOnly: true},
+		},
+		{name: "unknown flag", words: []string{"--verbose", "build"}, wantArgs: []string{"build"}},
+		{
+			name:     "conclusions",
+			words:    []string{"--conclusions", "failure,Cancelled", "build"},
+			wantArgs: []string{"build"},
+			wantOpts: rerunOptions{conclusions: map[string]struct{}{"failure": {}, "cancelled": {}}},
+		},
+		{
+			name:     "conclusions with equals",
+			words:    []string{"--conclusions=timed_out"},
+			wantOpts: rerunOptions{conclusions: map[string]struct{}{"timed_out": {}}},
+		},
+		{name: "conclusions without value", words: []string{"--conclusions"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, opts := parseCommandArgs(tt.words)
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("got args %q, want %q", args, tt.wantArgs)
+			}
+			if !reflect.DeepEqual(opts, tt.wantOpts) {
+				t.Errorf("got options %+v, want %+v", opts, tt.wantOpts)
+			}
+		})
+	}
+}
+
+func TestCommandsToWorkflowNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []command
+		want     map[string]rerunOptions
+	}{
+		{name: "none", want: map[string]rerunOptions{}},
+		{
+			name:     "rerun all",
+			commands: []command{{Name: retestAllWorkflowsCommand}},
+			want:     map[string]rerunOptions{testAll: {}},
+		},
+		{
+			name:     "rerun workflows",
+			commands: []command{{Name: testWorkflowCommand, Args: []string{"build"}}, {Name: testWorkflowCommand, Args: []string{"lint"}}},
+			want:     map[string]rerunOptions{"build": {}, "lint": {}},
+		},
+		{
+			name:     "rerun workflow without name",
+			commands: []command{{Name: testWorkflowCommand}},
+			want:     map[string]rerunOptions{},
+		},
+		{
+			name:     "rerun workflow with flag",
+			commands: []command{{Name: testWorkflowCommand, Args: []string{"--failed-jobs-only", "build"}}},
+			want:     map[string]rerunOptions{"build": {failedJobsOnly: true}},
+		},
+		{
+			name:     "rerun check",
+			commands: []command{{Name: rerunCheckCommand, Args: []string{"unit", "tests"}}},
+			want:     map[string]rerunOptions{testCheckPrefix + "unit tests": {}},
+		},
+		{
+			name:     "rerun group",
+			commands: []command{{Name: rerunGroupCommand, Args: []string{"e2e"}}},
+			want:     map[string]rerunOptions{testGroupPrefix + "e2e": {skipIncomplete: true}},
+		},
+		{
+			name:     "rerun base",
+			commands: []command{{Name: rerunBaseCommand, Args: []string{"build"}}},
+			want:     map[string]rerunOptions{testBasePrefix + "build": {}},
+		},
+		{
+			name:     "rerun tag",
+			commands: []command{{Name: rerunTagCommand, Args: []string{"smoke"}}},
+			want:     map[string]rerunOptions{testTagPrefix + "smoke": {}},
+		},
+		{
+			name:     "rerun skipped",
+			commands: []command{{Name: rerunSkippedCommand, Args: []string{"docs"}}},
+			want:     map[string]rerunOptions{"docs": {dispatchSkipped: true}},
+		},
+		{
+			name: "special commands",
+			commands: []command{{Name: rerunStackCommand}, {Name: rerunAndMergeCommand}, {Name: removeOkToTestCommand},
+				{Name: rerunStaleCommand}},
+			want: map[string]rerunOptions{testStack: {}, testMerge: {}, testRemoveLabel: {}, testStale: {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := commandsToWorkflowNames(tt.commands); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommands(t *testing.T) {
+	rerunAll := command{Name: retestAllWorkflowsCommand, Args: []string{}}
+	rerunBuild := command{Name: testWorkflowCommand, Args: []string{"build"}}
+	mention := commandParser{mention: "@bot"}
+	requireMention := commandParser{mention: "@bot", requireMention: true}
+	tests := []struct {
+		name   string
+		parser commandParser
+		body   string
+		want   []command
+	}{
+		{name: "command", body: "/rerun-all", want: []command{rerunAll}},
+		{name: "command with arg", body: "/rerun-workflow build", want: []command{rerunBuild}},
+		{name: "commands", body: "/rerun-all\n/rerun-workflow build\n", want: []command{rerunAll, rerunBuild}},
+		{name: "extra spaces", body: "  /rerun-workflow   build ", want: []command{rerunBuild}},
+		{name: "unknown command", body: "/rerun-everything\n/rerun-all", want: []command{rerunAll}},
+		{name: "no command", body: "LGTM"},
+		{name: "command and text", body: "/rerun-all\nthanks!"},
+		{name: "blank line", body: "/rerun-all\n\n/rerun-workflow build"},
+		{name: "mention before", parser: mention, body: "@bot /rerun-all", want: []command{rerunAll}},
+		{name: "mention after", parser: mention, body: "/rerun-all @BOT", want: []command{rerunAll}},
+		{name: "mention line", parser: mention, body: "@bot\n/rerun-all", want: []command{rerunAll}},
+		{name: "mention not required", parser: mention, body: "/rerun-all", want: []command{rerunAll}},
+		{name: "mention required", parser: requireMention, body: "/rerun-all"},
+		{name: "mention required and given", parser: requireMention, body: "@bot /rerun-all", want: []command{rerunAll}},
+		{name: "other mention", parser: requireMention, body: "@other /rerun-all"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.parser.parseCommands(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitReason(t *testing.T) {
+	tests := []struct {
+		name       string
+		words      []string
+		wantArgs   []string
+		wantReason string
+	}{
+		{name: "none", wantArgs: []string{}},
+		{name: "args", words: []string{"build"}, wantArgs: []string{"build"}},
+		{name: "reason", words: []string{"build", "#flaky"}, wantArgs: []string{"build"}, wantReason: "#flaky"},
+		{name: "reasons", words: []string{"#flaky", "build", "#infra"}, wantArgs: []string{"build"}, wantReason: "#flaky #infra"},
+		{name: "lone hash", words: []string{"#"}, wantArgs: []string{"#"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, reason := splitReason(tt.words)
+			if !reflect.DeepEqual(args, tt.wantArgs) || reason != tt.wantReason {
+				t.Errorf("got (%q, %q), want (%q, %q)", args, reason, tt.wantArgs, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCommandReasons(t *testing.T) {
+	commands := []command{{Reason: "#flaky"}, {}, {Reason: "#infra"}, {Reason: "#flaky"}}
+	if got, want := commandReasons(commands), []string{"#flaky", "#infra"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseCommandsRegex(t *testing.T) {
+	withArgs := commandParser{regex: regexp.MustCompile(`^!ci (?P<command>[a-z-]+)(?P<args>.*)$`)}
+	withoutArgs := commandParser{regex: regexp.MustCompile(`^please (?P<command>rerun-all)$`)}
+	tests := []struct {
+		name   string
+		parser commandParser
+		body   string
+		want   []command
+	}{
+		{name: "command", parser: withArgs, body: "!ci rerun-all", want: []command{{Name: retestAllWorkflowsCommand, Args: []string{}}}},
+		{
+			name:   "command with args",
+			parser: withArgs,
+			body:   "!ci rerun-workflow build #flaky",
+			want:   []command{{Name: testWorkflowCommand, Args: []string{"build"}, Reason: "#flaky"}},
+		},
+		{name: "unknown command", parser: withArgs, body: "!ci deploy"},
+		{name: "default syntax", parser: withArgs, body: "/rerun-all"},
+		{name: "no args group", parser: withoutArgs, body: "please rerun-all", want: []command{{Name: retestAllWorkflowsCommand, Args: []string{}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.parser.parseCommands(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeOptions(t *testing.T) {
+	failure := map[string]struct{}{failureConclusion: {}}
+	cancelled := map[string]struct{}{"cancelled": {}}
+	tests := []struct {
+		name string
+		a, b rerunOptions
+		want rerunOptions
+	}{
+		{name: "none"},
+		{name: "failed jobs only by both", a: rerunOptions{failedJobsOnly: true}, b: rerunOptions{failedJobsOnly: true},
+			want: rerunOptions{failedJobsOnly: true}},
+		{name: "failed jobs only by one", a: rerunOptions{failedJobsOnly: true}},
+		{name: "skip incomplete by one", b: rerunOptions{skipIncomplete: true}},
+		{name: "dispatch skipped by one", a: rerunOptions{dispatchSkipped: true}, want: rerunOptions{dispatchSkipped: true}},
+		{name: "conclusions by both", a: rerunOptions{conclusions: failure}, b: rerunOptions{conclusions: cancelled},
+			want: rerunOptions{conclusions: map[string]struct{}{failureConclusion: {}, "cancelled": {}}}},
+		{name: "conclusions by one", a: rerunOptions{conclusions: failure}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeOptions(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got := mergeOptions(tt.b, tt.a); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reversed: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandsToWorkflowNamesMerges(t *testing.T) {
+	commands := []command{
+		{Name: testWorkflowCommand, Args: []string{"--failed-jobs-only", "build"}},
+		{Name: testWorkflowCommand, Args: []string{"build"}},
+		{Name: testWorkflowCommand, Args: []string{"--failed-jobs-only", "lint"}},
+		{Name: testWorkflowCommand, Args: []string{"--failed-jobs-only", "lint"}},
+		{Name: retestAllWorkflowsCommand},
+	}
+	want := map[string]rerunOptions{"build": {}, "lint": {failedJobsOnly: true}, testAll: {}}
+	if got := commandsToWorkflowNames(commands); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseConclusions(t *testing.T) {
+	tests := []struct {
+		list string
+		want map[string]struct{}
+	}{
+		{list: ""},
+		{list: " , "},
+		{list: "failure", want: map[string]struct{}{"failure": {}}},
+		{list: "Failure, cancelled,,", want: map[string]struct{}{"failure": {}, "cancelled": {}}},
+	}
+	for _, tt := range tests {
+		if got := parseConclusions(tt.list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parse %q: got %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	regex := commandParser{regex: regexp.MustCompile(`^!ci (?P<command>[a-z-]+)(?P<args>.*)$`)}
+	tests := []struct {
+		name          string
+		parser        commandParser
+		words         []string
+		wantName      string
+		wantWords     []string
+		wantIsCommand bool
+	}{
+		{name: "slash", words: []string{"/rerun-workflows", "build"}, wantName: "rerun-workflows", wantWords: []string{"build"}, wantIsCommand: true},
+		{name: "slash no args", words: []string{"/rerun-all"}, wantName: "rerun-all", wantWords: []string{}, wantIsCommand: true},
+		{name: "shortest", words: []string{"/lgtm"}, wantName: "lgtm", wantWords: []string{}, wantIsCommand: true},
+		{name: "shorter than any command", words: []string{"/ok"}},
+		{name: "no slash", words: []string{"rerun-all"}},
+		{name: "regex", parser: regex, words: []string{"!ci", "rerun-workflows", "build", "lint"}, wantName: "rerun-workflows", wantWords: []string{"build", "lint"}, wantIsCommand: true},
+		{name: "regex no match", parser: regex, words: []string{"/rerun-all"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, words, isCommand := tt.parser.splitCommand(tt.words)
+			if name != tt.wantName || isCommand != tt.wantIsCommand || (isCommand && !reflect.DeepEqual(words, tt.wantWords)) {
+				t.Errorf("got %q, %q, %t, want %q, %q, %t", name, words, isCommand, tt.wantName, tt.wantWords, tt.wantIsCommand)
+			}
+		})
+	}
+}
+
+func TestAuthorizeCommands(t *testing.T) {
+	commands := []command{{Name: retestAllWorkflowsCommand}, {Name: testWorkflowCommand, Args: []string{"build"}}}
+	tests := []struct {
+		name         string
+		associations map[string]string
+		assoc        string
+		wantAllowed  []string
+		wantRejected []string
+	}{
+		{name: "unrestricted", assoc: "NONE", wantAllowed: []string{retestAllWorkflowsCommand, testWorkflowCommand}},
+		{
+			name:         "below minimum",
+			associations: map[string]string{retestAllWorkflowsCommand: "member"},
+			assoc:        "COLLABORATOR",
+			wantAllowed:  []string{testWorkflowCommand},
+			wantRejected: []string{retestAllWorkflowsCommand},
+		},
+		{
+			name:         "at minimum",
+			associations: map[string]string{retestAllWorkflowsCommand: "member"},
+			assoc:        "MEMBER",
+			wantAllowed:  []string{retestAllWorkflowsCommand, testWorkflowCommand},
+		},
+		{
+			name:         "all below minimum",
+			associations: map[string]string{retestAllWorkflowsCommand: "owner", testWorkflowCommand: "contributor"},
+			assoc:        "FIRST_TIMER",
+			wantRejected: []string{retestAllWorkflowsCommand, testWorkflowCommand},
+		},
+	}
+	names := func(commands []command) (names []string) {
+		for _, cmd := range commands {
+			names = append(names, cmd.Name)
+		}
+		return names
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{commandAssociations: tt.associations}
+			allowed, rejected := h.authorizeCommands(commands, tt.assoc)
+			if !reflect.DeepEqual(names(allowed), tt.wantAllowed) || !reflect.DeepEqual(names(rejected), tt.wantRejected) {
+				t.Errorf("got allowed %v rejected %v, want allowed %v rejected %v",
+					names(allowed), names(rejected), tt.wantAllowed, tt.wantRejected)
+			}
+		})
+	}
+}
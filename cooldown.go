@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// rerunTimesMarkerPrefix starts a hidden marker embedding rerunTimes as JSON in a summary comment.
+const rerunTimesMarkerPrefix = "<!-- rerun-actions-rerun-times "
+
+// rerunTimes records when each workflow with a cooldown was last rerun on a PR.
+type rerunTimes map[string]time.Time
+
+// parseRerunTimesMarker parses the rerun times marker in body. A nil rerunTimes is returned if body has no valid marker.
+func parseRerunTimesMarker(body string) rerunTimes {
+	i := strings.Index(body, rerunTimesMarkerPrefix)
+	if i < 0 {
+		return nil
+	}
+	data := body[i+len(rerunTimesMarkerPrefix):]
+	if end := strings.Index(data, " -->"); end >= 0 {
+		data = data[:end]
+	}
+	times := rerunTimes{}
+	if err := json.Unmarshal([]byte(data), &times); err != nil {
+		return nil
+	}
+	return times
+}
+
+// marker returns a hidden marker recording times.
+func (times rerunTimes) marker() string {
+	b, _ := json.Marshal(times)
+	return rerunTimesMarkerPrefix + string(b) + " -->"
+}
+
+// getRerunTimes returns the rerun times recorded in the latest bot summary on the PR numbered prNum.
+// Empty times are returned if there is no such summary.
+func (h *handler) getRerunTimes(ctx context.Context, repoOwner, repoName string, prNum int) (rerunTimes, error) {
+	comments, err := h.listBotComments(ctx, repoOwner, repoName, prNum, rerunTimesMarkerPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, comment := range comments {
+		if times := parseRerunTimesMarker(comment.GetBody()); times != nil {
+			return times, nil
+		}
+	}
+	return rerunTimes{}, nil
+}
+
+// cooldownEnd returns when workflowName's cooldown ends, if it was rerun too recently to be rerun again.
+func (h *handler) cooldownEnd(workflowName string) (end time.Time, onCooldown bool) {
+	cooldown, hasCooldown := h.workflowCooldowns[workflowName]
+	if !hasCooldown {
+		return time.Time{}, false
+	}
+	end = h.rerunTimes[workflowName].Add(cooldown)
+	return end, time.Now().Before(end)
+}
+
+// recordRerunTimes records the time results' workflows with cooldowns were rerun.
+func (h *handler) recordRerunTimes(results []rerunResult) {
+	now := time.Now().UTC()
+	for _, result := range results {
+		name := result.cooldownWorkflow()
+		if _, hasCooldown := h.workflowCooldowns[name]; hasCooldown && result.outcome == outcomeRerun {
+			h.rerunTimes[name] = now
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRerunTimesMarker(t *testing.T) {
+	times := rerunTimes{"build": time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	got := parseRerunTimesMarker("summary\n" + times.marker() + "\n")
+	if len(got) != 1 || !got["build"].Equal(times["build"]) {
+		t.Errorf("got %v, want %v", got, times)
+	}
+	for _, body := range []string{"", "no marker", rerunTimesMarkerPrefix + "not json -->"} {
+		if got := parseRerunTimesMarker(body); got != nil {
+			t.Errorf("parse %q: got %v, want nil", body, got)
+		}
+	}
+}
+
+func TestCooldownEnd(t *testing.T) {
+	now := time.Now()
+	h := &handler{
+		workflowCooldowns: map[string]time.Duration{"build": time.Hour, "lint": time.Hour},
+		rerunTimes:        rerunTimes{"build": now.Add(-time.Minute), "lint": now.Add(-2 * time.Hour)},
+	}
+	tests := []struct {
+		workflow string
+		want     bool
+	}{
+		{workflow: "build", want: true},
+		{workflow: "lint", want: false},
+		{workflow: "docs", want: false},
+	}
+	for _, tt := range tests {
+		if _, onCooldown := h.cooldownEnd(tt.workflow); onCooldown != tt.want {
+			t.Errorf("%s: got on cooldown %t, want %t", tt.workflow, onCooldown, tt.want)
+		}
+	}
+}
+
+func TestRecordRerunTimes(t *testing.T) {
+	h := &handler{workflowCooldowns: map[string]time.Duration{"build": time.Hour, "lint": time.Hour}, rerunTimes: rerunTimes{}}
+	h.recordRerunTimes([]rerunResult{
+		{workflowName: "build", outcome: outcomeRerun},
+		{workflowName: "lint", outcome: outcomeSkippedSucceeded},
+		{workflowName: "docs", outcome: outcomeRerun},
+	})
+	if _, recorded := h.rerunTimes["build"]; !recorded || len(h.rerunTimes) != 1 {
+		t.Errorf("got %v, want only build recorded", h.rerunTimes)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// dispatcherAssociation is the author association of a user dispatching commands. Only users with write access
+// can dispatch workflows, so they are treated as collaborators in place of a commenter's association.
+const dispatcherAssociation = "COLLABORATOR"
+
+// handleDispatch runs the commands in the command input on the PR numbered by the pr_number input,
+// for a workflow_dispatch event. The user dispatching the workflow stands in for a commenter.
+func (h *handler) handleDispatch(ctx context.Context, repoOwner, repoName string) error {
+	prNum, err := strconv.Atoi(h.GetInput("pr_number"))
+	if err != nil || prNum <= 0 {
+		return fmt.Errorf("pr_number %q must be a positive integer", h.GetInput("pr_number"))
+	}
+	body := h.GetInput("command")
+	if body == "" {
+		return fmt.Errorf("empty command")
+	}
+	comment := &github.IssueComment{
+		Body:              &body,
+		User:              &github.User{Login: github.String(os.Getenv("GITHUB_ACTOR"))},
+		AuthorAssociation: github.String(dispatcherAssociation),
+		IssueURL:          github.String(fmt.Sprintf("repos/%v/%v/issues/%d", repoOwner, repoName, prNum)),
+	}
+	return h.handleComment(ctx, repoOwner, repoName, comment)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v33/github"
+)
+
+func TestHandleDispatch(t *testing.T) {
+	const membershipPath = "/orgs/org/teams/maintainers/memberships/dispatcher"
+	tests := []struct {
+		name       string
+		env        map[string]string
+		membership *github.Membership
+		wantErr    error
+		wantRerun  bool
+	}{
+		{name: "collaborator", wantRerun: true},
+		{
+			// The dispatcher is a collaborator, not a member, whatever their actual association.
+			name:    "below default_min_association",
+			env:     map[string]string{"INPUT_DEFAULT_MIN_ASSOCIATION": "member"},
+			wantErr: errUnauthorized,
+		},
+		{name: "at default_min_association", env: map[string]string{"INPUT_DEFAULT_MIN_ASSOCIATION": "collaborator"}, wantRerun: true},
+		{
+			name:    "below command_associations",
+			env:     map[string]string{"INPUT_COMMAND_ASSOCIATIONS": "rerun-all=owner"},
+			wantErr: errUnauthorized,
+		},
+		{name: "at command_associations", env: map[string]string{"INPUT_COMMAND_ASSOCIATIONS": "rerun-all=collaborator"}, wantRerun: true},
+		{
+			name:       "maintainers team member",
+			env:        map[string]string{"INPUT_MAINTAINERS_TEAM": "org/maintainers"},
+			membership: &github.Membership{State: github.String("active")},
+			wantRerun:  true,
+		},
+		{
+			// Team membership replaces the dispatcher's association, so collaborators outside the team are rejected.
+			name:    "not a maintainers team member",
+			env:     map[string]string{"INPUT_MAINTAINERS_TEAM": "org/maintainers"},
+			wantErr: errUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"INPUT_PR_NUMBER": "1", "INPUT_COMMAND": "/rerun-all", "GITHUB_ACTOR": "dispatcher"}
+			for name, value := range tt.env {
+				env[name] = value
+			}
+			defer setTestEnv(t, env)()
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10)
+			if tt.membership != nil {
+				api.handle(http.MethodGet, membershipPath, http.StatusOK, tt.membership)
+			}
+			h := newTestHandler(t, api)
+
+			if err := h.handleDispatch(context.Background(), testOwner, testRepo); err != tt.wantErr {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != tt.wantRerun {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantRerun)
+			}
+			if tt.env["INPUT_MAINTAINERS_TEAM"] != "" && !api.called(http.MethodGet, membershipPath) {
+				t.Errorf("dispatcher's team membership was not checked")
+			}
+		})
+	}
+}
+
+func TestHandleDispatchInputs(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{name: "no PR", env: map[string]string{"INPUT_COMMAND": "/rerun-all"}},
+		{name: "bad PR", env: map[string]string{"INPUT_PR_NUMBER": "-1", "INPUT_COMMAND": "/rerun-all"}},
+		{name: "no command", env: map[string]string{"INPUT_PR_NUMBER": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setTestEnv(t, tt.env)()
+			api := newFakeAPI(t)
+			h := newTestHandler(t, api)
+			if err := h.handleDispatch(context.Background(), testOwner, testRepo); err == nil || isRejection(err) {
+				t.Errorf("got error %v, want an input error", err)
+			}
+			if len(api.requests) != 0 {
+				t.Errorf("got %d API requests, want none", len(api.requests))
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// handlePREdited runs commands added to a PR's description by a pull_request "edited" event,
+// read from the file at GITHUB_EVENT_PATH. Commands already in the description are not run again, and edits
+// that do not add commands, or that are made by someone other than the PR's author, are ignored.
+func (h *handler) handlePREdited(ctx context.Context, repoOwner, repoName string) error {
+	event, err := readPullRequestEvent(os.Getenv("GITHUB_EVENT_PATH"))
+	if err != nil {
+		return err
+	}
+	if event.GetAction() != "edited" || event.GetChanges().Body == nil {
+		h.Debugf("PR description was not edited (action: %s)", event.GetAction())
+		return errNoCommand
+	}
+
+	pr := event.GetPullRequest()
+	body := pr.GetBody()
+	if len(h.parser.parseCommands(body)) == 0 {
+		h.Debugf("PR %d description has no commands", pr.GetNumber())
+		return errNoCommand
+	}
+	// Only dedupe on command lines, so fixing a typo elsewhere in the description does not trigger reruns again.
+	if from := event.GetChanges().Body.From; from != nil {
+		body = h.parser.addedCommandLines(body, *from)
+	}
+	if len(h.parser.parseCommands(body)) == 0 {
+		h.Debugf("PR %d description commands are unchanged", pr.GetNumber())
+		return errNoCommand
+	}
+
+	// The edit event does not carry the editor's association with the repo, only the PR author's.
+	if event.GetSender().GetLogin() != pr.GetUser().GetLogin() {
+		h.Debugf("PR %d description was edited by %s, not its author", pr.GetNumber(), event.GetSender().GetLogin())
+		return errUnauthorized
+	}
+
+	// The description is handled as a comment by the PR's author.
+	comment := &github.IssueComment{
+		Body:              github.String(body),
+		User:              pr.User,
+		AuthorAssociation: pr.AuthorAssociation,
+		IssueURL:          pr.IssueURL,
+	}
+	return h.handleComment(ctx, repoOwner, repoName, comment)
+}
+
+// addedCommandLines returns the lines of body that are not lines of previous and contain commands, with lines
+// that contain none, ex. only p.mention, so commands already in previous are not run again.
+func (p commandParser) addedCommandLines(body, previous string) string {
+	previousLines := make(map[string]struct{})
+	for _, line := range strings.Split(previous, "\n") {
+		previousLines[strings.TrimSpace(line)] = struct{}{}
+	}
+	// A line is checked for commands on its own, so its mention may be on another line.
+	lineParser := p
+	lineParser.requireMention = false
+	var added []string
+	for _, line := range strings.Split(body, "\n") {
+		_, isPrevious := previousLines[strings.TrimSpace(line)]
+		if !isPrevious || len(lineParser.parseCommands(line)) == 0 {
+			added = append(added, line)
+		}
+	}
+	return strings.Join(added, "\n")
+}
+
+// readPullRequestEvent decodes the pull_request event payload at path.
+func readPullRequestEvent(path string) (*github.PullRequestEvent, error) {
+	if path == "" {
+		return nil, fmt.Errorf("GITHUB_EVENT_PATH not set")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read event: %v", err)
+	}
+	event := &github.PullRequestEvent{}
+	if err := json.Unmarshal(b, event); err != nil {
+		return nil, fmt.Errorf("decode event: %v", err)
+	}
+	return event, nil
+}
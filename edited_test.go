@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// writeTestEvent writes event to a file and points GITHUB_EVENT_PATH at it until the returned func is called.
+func writeTestEvent(t *testing.T, event interface{}) func() {
+	f, err := ioutil.TempFile("", "event-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(event); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv("GITHUB_EVENT_PATH", f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Unsetenv("GITHUB_EVENT_PATH")
+		os.Remove(f.Name())
+	}
+}
+
+func TestAddedCommandLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		parser   commandParser
+		body     string
+		previous string
+		want     string
+	}{
+		{name: "added", body: "/rerun-workflow build\n/rerun-workflow lint", previous: "/rerun-workflow build", want: "/rerun-workflow lint"},
+		{name: "unchanged", body: "/rerun-all", previous: "/rerun-all", want: ""},
+		{name: "changed args", body: "/rerun-workflow build lint", previous: "/rerun-workflow build", want: "/rerun-workflow build lint"},
+		{
+			name:     "mention kept",
+			parser:   commandParser{mention: "@bot", requireMention: true},
+			body:     "@bot\n/rerun-all\n/rerun-workflow lint",
+			previous: "@bot\n/rerun-all",
+			want:     "@bot\n/rerun-workflow lint",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.parser.addedCommandLines(tt.body, tt.previous); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlePREdited(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    string
+		from      *string
+		body      string
+		sender    string
+		wantErr   error
+		wantReran []int64
+	}{
+		{name: "command added", from: github.String("Fixes a bug"), body: "/rerun-workflow build", wantReran: []int64{10}},
+		{
+			name:      "second command added",
+			from:      github.String("/rerun-workflow build"),
+			body:      "/rerun-workflow build\n/rerun-workflow lint",
+			wantReran: []int64{20},
+		},
+		{name: "commands unchanged", from: github.String("/rerun-all"), body: "/rerun-all", wantErr: errNoCommand},
+		{name: "no commands", from: github.String("/rerun-all"), body: "Fixes a bug", wantErr: errNoCommand},
+		{name: "not edited", action: "opened", body: "/rerun-all", wantErr: errNoCommand},
+		{name: "edited by someone else", from: github.String(""), body: "/rerun-all", sender: "other", wantErr: errUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "lint")},
+				map[int64][]*github.WorkflowRun{
+					1: {testRun(10, 1, testHeadSHA, failureConclusion)},
+					2: {testRun(20, 2, testHeadSHA, failureConclusion)},
+				})
+			for _, id := range []int64{10, 20} {
+				api.handle(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id), http.StatusCreated, nil)
+			}
+			h := newTestHandler(t, api)
+
+			pr := testPR()
+			pr.Body = github.String(tt.body)
+			pr.AuthorAssociation = github.String("MEMBER")
+			pr.IssueURL = github.String(api.url("/repos/o/r/issues/1"))
+			event := &github.PullRequestEvent{
+				Action:      github.String("edited"),
+				PullRequest: pr,
+				Sender:      pr.GetUser(),
+			}
+			if tt.action != "" {
+				event.Action = github.String(tt.action)
+			}
+			if tt.from != nil {
+				event.Changes = &github.EditChange{}
+				event.Changes.Body = &struct {
+					From *string `json:"from,omitempty"`
+				}{From: tt.from}
+			}
+			if tt.sender != "" {
+				event.Sender = &github.User{Login: github.String(tt.sender)}
+			}
+			defer writeTestEvent(t, event)()
+
+			if err := h.handlePREdited(context.Background(), testOwner, testRepo); err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			for _, id := range []int64{10, 20} {
+				want := containsID(tt.wantReran, id)
+				if rerun := api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id)); rerun != want {
+					t.Errorf("run %d rerun: got %t, want %t", id, rerun, want)
+				}
+			}
+		})
+	}
+}
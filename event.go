@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Outcomes of handling a comment, reported in its event line.
+const (
+	eventSucceeded = "succeeded"
+	eventFailed    = "failed"
+	eventSkipped   = "skipped"
+	eventRejected  = "rejected"
+	eventErrored   = "errored"
+)
+
+// commandEvent is the single line describing a handled comment, for log-based metrics and alerts.
+type commandEvent struct {
+	Repo      string   `json:"repo"`
+	PR        int      `json:"pr"`
+	Commenter string   `json:"commenter"`
+	Commands  []string `json:"commands"`
+	Reruns    int      `json:"reruns"`
+	// Dispatched counts workflows dispatched on the PR's head rather than rerun.
+	Dispatched int    `json:"dispatched,omitempty"`
+	Outcome    string `json:"outcome"`
+	// Reason is why a comment was rejected or errored.
+	Reason string `json:"reason,omitempty"`
+}
+
+// newCommandEvent describes commands in a comment by login on repoOwner/repoName, which did nothing until
+// the event is updated with the comment's results.
+func newCommandEvent(repoOwner, repoName, login string, commands []command) *commandEvent {
+	event := &commandEvent{
+		Repo:      repoOwner + "/" + repoName,
+		Commenter: login,
+		Commands:  make([]string, 0, len(commands)),
+		Outcome:   eventSkipped,
+	}
+	for _, cmd := range commands {
+		event.Commands = append(event.Commands, "/"+cmd.Name)
+	}
+	return event
+}
+
+// setResults records results in event, which failed if any of results did, unless it already errored.
+func (event *commandEvent) setResults(results []rerunResult) {
+	event.Reruns, event.Dispatched = 0, 0
+	for _, result := range results {
+		if result.started() {
+			event.Reruns++
+		}
+		if result.dispatched() {
+			event.Dispatched++
+		}
+	}
+	if event.Outcome == eventErrored {
+		return
+	}
+	event.Outcome = eventSucceeded
+	if anyFailed(results) {
+		event.Outcome = eventFailed
+	}
+}
+
+// setErrored records that handling errored because what failed with err, ex. an API call that was logged
+// without failing the job.
+func (event *commandEvent) setErrored(what string, err error) {
+	event.Outcome, event.Reason = eventErrored, fmt.Sprintf("%s: %v", what, err)
+}
+
+// emitEvent prints event as a JSON line to stdout, with err being what handling the comment returned.
+func (h *handler) emitEvent(event *commandEvent, err error) {
+	switch {
+	case err == nil:
+	case isRejection(err):
+		event.Outcome, event.Reason = eventRejected, err.Error()
+	default:
+		event.Outcome, event.Reason = eventErrored, err.Error()
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		h.Errorf("Failed to encode event: %v", err)
+		return
+	}
+	fmt.Fprintln(os.Stdout, string(b))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		buf := &bytes.Buffer{}
+		io.Copy(buf, r)
+		out <- buf.String()
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestCommandEventSetResults(t *testing.T) {
+	tests := []struct {
+		name           string
+		results        []rerunResult
+		wantOutcome    string
+		wantReruns     int
+		wantDispatched int
+	}{
+		{name: "none", wantOutcome: eventSucceeded},
+		{
+			name:        "reruns",
+			results:     []rerunResult{{outcome: outcomeRerun}, {outcome: outcomeApproved}, {outcome: outcomeSkippedSucceeded}},
+			wantOutcome: eventSucceeded,
+			wantReruns:  2,
+		},
+		{
+			name:           "dispatches",
+			results:        []rerunResult{{outcome: outcomeDispatched}, {outcome: outcomeRerun}},
+			wantOutcome:    eventSucceeded,
+			wantReruns:     1,
+			wantDispatched: 1,
+		},
+		{
+			name:        "failure",
+			results:     []rerunResult{{outcome: outcomeRerun}, {outcome: outcomeRerunFailed}},
+			wantOutcome: eventFailed,
+			wantReruns:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := newCommandEvent(testOwner, testRepo, "alice", []command{{Name: retestAllWorkflowsCommand}})
+			event.setResults(tt.results)
+			if event.Outcome != tt.wantOutcome || event.Reruns != tt.wantReruns || event.Dispatched != tt.wantDispatched {
+				t.Errorf("got %+v, want outcome %s, %d reruns, %d dispatched", event, tt.wantOutcome, tt.wantReruns, tt.wantDispatched)
+			}
+		})
+	}
+}
+
+func TestCommandEventErrored(t *testing.T) {
+	event := newCommandEvent(testOwner, testRepo, "alice", nil)
+	event.setErrored("rerun base branch workflows", errors.New("boom"))
+	event.setResults([]rerunResult{{outcome: outcomeRerun}})
+	if event.Outcome != eventErrored || event.Reason != "rerun base branch workflows: boom" || event.Reruns != 1 {
+		t.Errorf("got %+v, want an errored event with 1 rerun", event)
+	}
+}
+
+func TestEmitEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantOutcome string
+	}{
+		{name: "handled", wantOutcome: eventSkipped},
+		{name: "rejected", err: errUnauthorized, wantOutcome: eventRejected},
+		{name: "errored", err: errors.New("boom"), wantOutcome: eventErrored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{}
+			event := newCommandEvent(testOwner, testRepo, "alice", []command{{Name: retestAllWorkflowsCommand}})
+			out := captureStdout(t, func() { h.emitEvent(event, tt.err) })
+			var got commandEvent
+			if err := json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatalf("decode %q: %v", out, err)
+			}
+			if got.Outcome != tt.wantOutcome || got.Repo != "o/r" || !strings.HasSuffix(out, "}\n") {
+				t.Errorf("got %q, want a line with outcome %s", out, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestHandleCommentEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		prStatus    int
+		association string
+		wantOutcome string
+		wantReason  string
+	}{
+		{name: "succeeded", prStatus: http.StatusOK, wantOutcome: eventSucceeded},
+		{name: "rejected", prStatus: http.StatusOK, association: "NONE", wantOutcome: eventRejected, wantReason: errUnauthorized.Error()},
+		{name: "errored", prStatus: http.StatusInternalServerError, wantOutcome: eventErrored, wantReason: "get PR: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", tt.prStatus, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/rerun", http.StatusCreated, nil)
+			h := newTestHandler(t, api)
+			h.jsonEvent = true
+			comment := testComment(api, "/rerun-all")
+			if tt.association != "" {
+				comment.AuthorAssociation = github.String(tt.association)
+			}
+
+			out := captureStdout(t, func() { h.handleComment(context.Background(), testOwner, testRepo, comment) })
+			var event commandEvent
+			for _, line := range strings.Split(out, "\n") {
+				if strings.HasPrefix(line, "{") {
+					if err := json.Unmarshal([]byte(line), &event); err != nil {
+						t.Fatalf("decode %q: %v", line, err)
+					}
+				}
+			}
+			if event.Outcome != tt.wantOutcome || !strings.HasPrefix(event.Reason, tt.wantReason) {
+				t.Errorf("got %+v, want outcome %s and reason %q", event, tt.wantOutcome, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadInputs parses optional inputs into h, returning an error for each invalid input.
+func (h *handler) loadInputs() []error {
+	h.inputErrs = nil
+
+	if perPage := h.getIntInput("runs_per_page"); perPage > maxRunsPerPage {
+		h.Debugf("runs_per_page %d exceeds the API maximum, using %d", perPage, maxRunsPerPage)
+		h.runsPerPage = maxRunsPerPage
+	} else {
+		h.runsPerPage = perPage
+	}
+
+	h.verbose = h.getBoolInput("verbose")
+	h.matchMergeRef = h.getBoolInput("match_merge_ref")
+	h.sinceLastPush = h.getBoolInput("since_last_push")
+	h.runEvents = make(map[string]struct{})
+	for _, event := range h.getListInput("run_events") {
+		h.runEvents[event] = struct{}{}
+	}
+	if len(h.runEvents) == 0 {
+		h.runEvents[defaultRunEvent] = struct{}{}
+	}
+	h.excludeEvents = make(map[string]struct{})
+	for _, event := range h.getListInput("exclude_events") {
+		h.excludeEvents[event] = struct{}{}
+	}
+
+	switch h.rerunAllScope = h.GetInput("rerun_all_scope"); h.rerunAllScope {
+	case "":
+		h.rerunAllScope = rerunAllScopeAll
+	case rerunAllScopeAll, rerunAllScopeRequired, rerunAllScopeActiveNonBlocklisted:
+	default:
+		h.invalidInput("rerun_all_scope %q must be one of %q, %q, or %q", h.rerunAllScope,
+			rerunAllScopeAll, rerunAllScopeRequired, rerunAllScopeActiveNonBlocklisted)
+	}
+	switch h.rerunOrder = h.GetInput("rerun_order"); h.rerunOrder {
+	case "", rerunOrderName, rerunOrderPath, rerunOrderID, rerunOrderCreated:
+	default:
+		h.invalidInput("rerun_order %q must be one of %q, %q, %q, or %q", h.rerunOrder,
+			rerunOrderName, rerunOrderPath, rerunOrderID, rerunOrderCreated)
+	}
+	h.workflowBlocklist = make(map[string]struct{})
+	for _, name := range h.getListInput("workflow_blocklist") {
+		h.workflowBlocklist[name] = struct{}{}
+	}
+
+	h.postSummary = h.getBoolInput("post_summary")
+	h.attributeCommenter = h.getBoolInput("attribute_commenter")
+	if h.attributeCommenter && !h.postSummary {
+		h.invalidInput("attribute_commenter requires post_summary")
+	}
+	h.chunkSummary = h.getBoolInput("chunk_summary")
+	if h.chunkSummary && !h.postSummary {
+		h.invalidInput("chunk_summary requires post_summary")
+	}
+	h.compactReply = h.getBoolInput("compact_reply")
+	if h.compactReply && !h.postSummary {
+		h.invalidInput("compact_reply requires post_summary")
+	}
+	h.consolidateSummary = h.getBoolInput("consolidate_summary")
+	if h.consolidateSummary && !h.postSummary {
+		h.invalidInput("consolidate_summary requires post_summary")
+	}
+	if h.consolidateSummary && h.chunkSummary {
+		h.invalidInput("consolidate_summary and chunk_summary are mutually exclusive")
+	}
+	h.summaryCheckRun = h.getBoolInput("summary_check_run")
+	h.selectionReasons = h.getBoolInput("selection_reasons")
+	if h.selectionReasons && !h.postSummary && !h.summaryCheckRun {
+		h.invalidInput("selection_reasons requires post_summary or summary_check_run")
+	}
+	h.jsonEvent = h.getBoolInput("json_event")
+	h.logSnippets = h.getBoolInput("log_snippets")
+	if h.logSnippets && !h.postSummary {
+		h.invalidInput("log_snippets requires post_summary")
+	}
+	h.rerunStats = h.getBoolInput("rerun_stats")
+	if h.rerunStats && !h.postSummary {
+		h.invalidInput("rerun_stats requires post_summary")
+	}
+	h.commentScanDepth = h.getIntInput("comment_scan_depth")
+	h.commentScanMaxAge = h.getDurationInput("comment_scan_max_age")
+	h.reactionStatus = h.getBoolInput("reaction_status")
+	if h.reactionStatus && h.postSummary {
+		h.invalidInput("reaction_status and post_summary are mutually exclusive")
+	}
+	h.earlyAck = h.getBoolInput("early_ack")
+	h.noticeSummary = h.getBoolInput("notice_summary")
+	h.ignoreBeforeLastAction = h.getBoolInput("ignore_before_last_action")
+	if h.ignoreBeforeLastAction && !h.postSummary {
+		h.invalidInput("ignore_before_last_action requires post_summary")
+	}
+	h.debounce = h.getDurationInput("debounce")
+	h.minSettle = h.getDurationInput("min_settle")
+
+	if h.parser.mention = h.GetInput("mention"); h.parser.mention != "" && !strings.HasPrefix(h.parser.mention, "@") {
+		h.parser.mention = "@" + h.parser.mention
+	}
+	if expr := h.GetInput("command_regex"); expr != "" {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			h.invalidInput("command_regex %q is invalid: %v", expr, err)
+		} else if regex.SubexpIndex(commandRegexCommandGroup) < 0 {
+			h.invalidInput("command_regex %q must have a capture group named %q", expr, commandRegexCommandGroup)
+		} else {
+			h.parser.regex = regex
+		}
+	}
+	h.parser.requireMention = h.getBoolInput("require_mention")
+	if h.parser.requireMention && h.parser.mention == "" {
+		h.invalidInput("require_mention requires mention")
+	}
+
+	h.descriptionCommands = h.getBoolInput("description_commands")
+
+	h.labelAssociations = make(map[string]string)
+	for _, pair := range h.getListInput("label_associations") {
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 || !h.isAssociation("label_associations", split[1]) {
+			h.invalidInput("label_associations element %q must be of the form <label>=<association>", pair)
+			continue
+		}
+		h.labelAssociations[strings.TrimSpace(split[0])] = strings.ToLower(strings.TrimSpace(split[1]))
+	}
+	h.commandAssociations = make(map[string]string)
+	for _, pair := range h.getListInput("command_associations") {
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 || !h.isAssociation("command_associations", split[1]) {
+			h.invalidInput("command_associations element %q must be of the form <command>=<association>", pair)
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimSpace(split[0]), "/")
+		if !isKnownCommand(name) {
+			h.invalidInput("command_associations: unknown command %q", split[0])
+			continue
+		}
+		h.commandAssociations[name] = strings.ToLower(strings.TrimSpace(split[1]))
+	}
+	h.requireOrgMembership = h.getBoolInput("require_org_membership")
+	h.firstTimerApproval = h.getBoolInput("first_timer_approval")
+	if h.botLogin = h.GetInput("bot_login"); h.botLogin == "" {
+		h.botLogin = defaultBotLogin
+	}
+	h.trustedRelayBots = make(map[string]struct{})
+	for _, login := range h.getListInput("trusted_relay_bots") {
+		h.trustedRelayBots[login] = struct{}{}
+	}
+	if h.defaultMinAssociation = strings.ToLower(h.GetInput("default_min_association")); h.defaultMinAssociation != "" {
+		h.isAssociation("default_min_association", h.defaultMinAssociation)
+	}
+	if h.maintainersTeam = h.GetInput("maintainers_team"); h.maintainersTeam != "" {
+		if split := strings.SplitN(h.maintainersTeam, "/", 2); len(split) != 2 || split[0] == "" || split[1] == "" {
+			h.invalidInput("maintainers_team %q must be of the form <org>/<team>", h.maintainersTeam)
+		}
+		if len(h.labelAssociations) != 0 || h.defaultMinAssociation != "" {
+			h.invalidInput("maintainers_team is mutually exclusive with label_associations and default_min_association")
+		}
+	}
+
+	h.ignoreNoWorkflows = h.getBoolInput("ignore_no_workflows")
+	h.enableDisabledWorkflows = h.getBoolInput("enable_disabled_workflows")
+	h.rerunSuccessWithin = h.getDurationInput("rerun_success_within")
+	h.workflowCooldowns = make(map[string]time.Duration)
+	for _, pair := range h.getListInput("workflow_cooldowns") {
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 {
+			h.invalidInput("workflow_cooldowns element %q must be of the form <workflow>=<duration>", pair)
+			continue
+		}
+		cooldown, err := time.ParseDuration(strings.TrimSpace(split[1]))
+		if err != nil || cooldown <= 0 {
+			h.invalidInput("workflow_cooldowns: %q must be a positive duration", split[1])
+			continue
+		}
+		h.workflowCooldowns[strings.TrimSpace(split[0])] = cooldown
+	}
+	if len(h.workflowCooldowns) != 0 && !h.postSummary {
+		h.invalidInput("workflow_cooldowns requires post_summary")
+	}
+	h.reportApprovalNeeded = h.getBoolInput("report_approval_needed")
+	h.skipIfRequiredGreen = h.getBoolInput("skip_if_required_green")
+	h.neverCancel = h.getBoolInput("never_cancel")
+	switch incompleteRuns := h.GetInput("incomplete_runs"); incompleteRuns {
+	case "", incompleteRunsSkip:
+	case incompleteRunsWait:
+		h.waitIncomplete = true
+	default:
+		h.invalidInput("incomplete_runs %q must be one of %q or %q", incompleteRuns, incompleteRunsSkip, incompleteRunsWait)
+	}
+	if h.waitIncomplete && !h.neverCancel {
+		h.invalidInput("incomplete_runs requires never_cancel")
+	}
+	switch h.notRerunnable = h.GetInput("not_rerunnable"); h.notRerunnable {
+	case "":
+		h.notRerunnable = notRerunnableFail
+	case notRerunnableFail, notRerunnableRetry, notRerunnableSkip:
+	default:
+		h.invalidInput("not_rerunnable %q must be one of %q, %q, or %q", h.notRerunnable,
+			notRerunnableFail, notRerunnableRetry, notRerunnableSkip)
+	}
+	h.cancelGrace = h.getDurationInput("cancel_grace")
+	if h.cancelGrace > 0 && h.neverCancel {
+		h.invalidInput("cancel_grace and never_cancel are mutually exclusive")
+	}
+	h.waitForCompletion = h.getBoolInput("wait_for_completion")
+	h.failedJobLinks = h.getBoolInput("failed_job_links")
+	if h.failedJobLinks && !h.waitForCompletion {
+		h.invalidInput("failed_job_links requires wait_for_completion")
+	}
+	if h.waitTimeout = h.getDurationInput("wait_timeout"); h.waitTimeout == 0 {
+		h.waitTimeout = defaultWaitTimeout
+	}
+	h.mergeBranches = make(map[string]struct{})
+	for _, branch := range h.getListInput("merge_branches") {
+		h.mergeBranches[branch] = struct{}{}
+	}
+
+	if h.workflowTagKey = h.GetInput("workflow_tag_key"); h.workflowTagKey == "" {
+		h.workflowTagKey = defaultWorkflowTagKey
+	}
+	h.selfWorkflowPath = strings.TrimPrefix(h.GetInput("self_workflow_path"), "./")
+	h.workflowPaths = h.getListInput("workflow_paths")
+	h.workflowGroups = make(map[string][]string)
+	for _, pair := range h.getListInput("workflow_groups") {
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 || strings.TrimSpace(split[0]) == "" {
+			h.invalidInput("workflow_groups element %q must be of the form <group>=<workflow>|<workflow>", pair)
+			continue
+		}
+		group := strings.TrimSpace(split[0])
+		for _, member := range strings.Split(split[1], "|") {
+			if member = strings.TrimSpace(member); member != "" {
+				h.workflowGroups[group] = append(h.workflowGroups[group], member)
+			}
+		}
+		if len(h.workflowGroups[group]) == 0 {
+			h.invalidInput("workflow_groups group %q has no members", group)
+		}
+	}
+
+	h.scheduleLabel = h.GetInput("schedule_label")
+	if h.scheduleMaxPRs = h.getIntInput("schedule_max_prs"); h.scheduleMaxPRs == 0 {
+		h.scheduleMaxPRs = defaultScheduleMaxPRs
+	}
+
+	return h.inputErrs
+}
+
+// isAssociation returns true if assoc is an author association, recording an error for input name otherwise.
+func (h *handler) isAssociation(name, assoc string) bool {
+	if _, isAssoc := associationRanks[strings.ToLower(strings.TrimSpace(assoc))]; !isAssoc {
+		h.invalidInput("%s: unknown author association %q", name, assoc)
+		return false
+	}
+	return true
+}
+
+// invalidInput records an input error.
+func (h *handler) invalidInput(format string, args ...interface{}) {
+	h.inputErrs = append(h.inputErrs, fmt.Errorf(format, args...))
+}
+
+// getIntInput parses the named input as a non-negative integer. Unset inputs are zero.
+func (h *handler) getIntInput(name string) int {
+	str := h.GetInput(name)
+	if str == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(str)
+	if err != nil || i < 0 {
+		h.invalidInput("%s %q must be a non-negative integer", name, str)
+		return 0
+	}
+	return i
+}
+
+// getDurationInput parses the named input as a duration, ex. "30s". Unset inputs are zero.
+func (h *handler) getDurationInput(name string) time.Duration {
+	str := h.GetInput(name)
+	if str == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil || d < 0 {
+		h.invalidInput("%s %q must be a non-negative duration", name, str)
+		return 0
+	}
+	return d
+}
+
+// getListInput parses the named input as a comma-separated list, ignoring empty elements.
+func (h *handler) getListInput(name string) (list []string) {
+	for _, elem := range strings.Split(h.GetInput(name), ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			list = append(list, elem)
+		}
+	}
+	return list
+}
+
+// getBoolInput parses the named input as a boolean. Unset inputs are false.
+func (h *handler) getBoolInput(name string) bool {
+	str := h.GetInput(name)
+	if str == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		h.invalidInput("%s %q must be a boolean", name, str)
+		return false
+	}
+	return b
+}
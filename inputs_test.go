@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	actions "github.com/sethvargo/go-githubactions"
+)
+
+// setTestEnv sets env, ex. {"INPUT_MENTION": "@bot"}, until the returned func is called.
+func setTestEnv(t *testing.T, env map[string]string) (unset func()) {
+	for name, value := range env {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// loadTestInputs loads inputs set to env, ex. {"INPUT_MENTION": "@bot"}, returning the handler and any errors.
+func loadTestInputs(t *testing.T, env map[string]string) (*handler, []error) {
+	defer setTestEnv(t, env)()
+	h := &handler{Action: actions.New()}
+	return h, h.loadInputs()
+}
+
+func TestLoadInputsCommandRegex(t *testing.T) {
+	tests := []struct {
+		name    string
+		regex   string
+		wantErr bool
+	}{
+		{name: "valid", regex: `^!ci (?P<command>[a-z-]+)(?P<args>.*)$`},
+		{name: "invalid", regex: `^!ci (`, wantErr: true},
+		{name: "no command group", regex: `^!ci ([a-z-]+)$`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, errs := loadTestInputs(t, map[string]string{"INPUT_COMMAND_REGEX": tt.regex})
+			if gotErr := len(errs) != 0; gotErr != tt.wantErr {
+				t.Fatalf("got errors %v, want error: %t", errs, tt.wantErr)
+			}
+			if !tt.wantErr && h.parser.regex == nil {
+				t.Errorf("command_regex not set")
+			}
+		})
+	}
+}
+
+func TestLoadInputsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "bad duration",
+			env:     map[string]string{"INPUT_DEBOUNCE": "soon"},
+			wantErr: `debounce "soon" must be a non-negative duration`,
+		},
+		{
+			name:    "negative duration",
+			env:     map[string]string{"INPUT_MIN_SETTLE": "-1m"},
+			wantErr: `min_settle "-1m" must be a non-negative duration`,
+		},
+		{
+			name:    "bad integer",
+			env:     map[string]string{"INPUT_RUNS_PER_PAGE": "many"},
+			wantErr: `runs_per_page "many" must be a non-negative integer`,
+		},
+		{
+			name:    "bad boolean",
+			env:     map[string]string{"INPUT_POST_SUMMARY": "yes please"},
+			wantErr: `post_summary "yes please" must be a boolean`,
+		},
+		{
+			name:    "unknown association",
+			env:     map[string]string{"INPUT_DEFAULT_MIN_ASSOCIATION": "maintainer"},
+			wantErr: `default_min_association: unknown author association "maintainer"`,
+		},
+		{
+			name:    "malformed label_associations",
+			env:     map[string]string{"INPUT_LABEL_ASSOCIATIONS": "ok-to-test"},
+			wantErr: `label_associations element "ok-to-test" must be of the form <label>=<association>`,
+		},
+		{
+			name:    "malformed maintainers_team",
+			env:     map[string]string{"INPUT_MAINTAINERS_TEAM": "maintainers"},
+			wantErr: `maintainers_team "maintainers" must be of the form <org>/<team>`,
+		},
+		{
+			name:    "unknown command association",
+			env:     map[string]string{"INPUT_COMMAND_ASSOCIATIONS": "/rerun-everything=member"},
+			wantErr: `command_associations: unknown command "/rerun-everything"`,
+		},
+		{
+			name:    "unknown choice",
+			env:     map[string]string{"INPUT_RERUN_ALL_SCOPE": "some"},
+			wantErr: `rerun_all_scope "some" must be one of "all", "required", or "active-nonblocklisted"`,
+		},
+		{
+			name:    "mutually exclusive",
+			env:     map[string]string{"INPUT_POST_SUMMARY": "true", "INPUT_REACTION_STATUS": "true"},
+			wantErr: "reaction_status and post_summary are mutually exclusive",
+		},
+		{
+			name: "maintainers_team with label_associations",
+			env: map[string]string{"INPUT_MAINTAINERS_TEAM": "org/maintainers",
+				"INPUT_LABEL_ASSOCIATIONS": "ok-to-test=contributor"},
+			wantErr: "maintainers_team is mutually exclusive with label_associations and default_min_association",
+		},
+		{
+			name:    "cooldown without post_summary",
+			env:     map[string]string{"INPUT_WORKFLOW_COOLDOWNS": "build=1h"},
+			wantErr: "workflow_cooldowns requires post_summary",
+		},
+		{
+			name:    "bad cooldown",
+			env:     map[string]string{"INPUT_POST_SUMMARY": "true", "INPUT_WORKFLOW_COOLDOWNS": "build=0s"},
+			wantErr: `workflow_cooldowns: "0s" must be a positive duration`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, errs := loadTestInputs(t, tt.env)
+			if len(errs) != 1 || errs[0].Error() != tt.wantErr {
+				t.Errorf("got errors %v, want %q", errs, tt.wantErr)
+			}
+			if len(h.inputErrs) != len(errs) {
+				t.Errorf("got %d inputErrs, want %d", len(h.inputErrs), len(errs))
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxLogArchiveSize bounds the size of a run's log archive downloaded for a snippet.
+	maxLogArchiveSize = 50 << 20
+	// maxLogSnippetLines and maxLogSnippetLength bound the size of a log snippet.
+	maxLogSnippetLines  = 20
+	maxLogSnippetLength = 2000
+	// logErrorMarker prefixes error lines in a run's logs.
+	logErrorMarker = "##[error]"
+)
+
+// getLogSnippet returns a snippet of the logs of the run with runID: its error lines or, if it has none,
+// the last lines of its last job's log.
+func (h *handler) getLogSnippet(ctx context.Context, repoOwner, repoName string, runID int64) (string, error) {
+	logsURL, _, err := h.Actions.GetWorkflowRunLogs(ctx, repoOwner, repoName, runID, false)
+	if err != nil {
+		return "", fmt.Errorf("get logs URL: %v", err)
+	}
+	// The URL is pre-authorized, so no token is needed to download the archive.
+	req, err := http.NewRequest(http.MethodGet, logsURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("create request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("download logs: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download logs: unexpected status %s", resp.Status)
+	}
+	archive, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxLogArchiveSize+1))
+	if err != nil {
+		return "", fmt.Errorf("download logs: %v", err)
+	}
+	if len(archive) > maxLogArchiveSize {
+		return "", fmt.Errorf("log archive exceeds %d bytes", maxLogArchiveSize)
+	}
+	return extractLogSnippet(archive)
+}
+
+// extractLogSnippet extracts a snippet from a log archive, which contains a log per job at its root
+// alongside directories of per-step logs.
+func extractLogSnippet(archive []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return "", fmt.Errorf("open log archive: %v", err)
+	}
+	var jobLogs []*zip.File
+	for _, f := range zr.File {
+		if !strings.Contains(f.Name, "/") {
+			jobLogs = append(jobLogs, f)
+		}
+	}
+	// Job logs are named after the order jobs ran in, ex. "1_build.txt".
+	sort.Slice(jobLogs, func(i, j int) bool { return jobLogs[i].Name < jobLogs[j].Name })
+
+	var errorLines, lastLines []string
+	for _, f := range jobLogs {
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("open %s: %v", f.Name, err)
+		}
+		lastLines = lastLines[:0]
+		scanner := bufio.NewScanner(rc)
+		for scanner.Scan() {
+			line := trimLogTimestamp(scanner.Text())
+			if strings.HasPrefix(line, logErrorMarker) {
+				errorLines = append(errorLines, line)
+			}
+			if lastLines = append(lastLines, line); len(lastLines) > maxLogSnippetLines {
+				lastLines = lastLines[1:]
+			}
+		}
+		rc.Close()
+		if err := scanner.Err(); err != nil {
+			return "", fmt.Errorf("read %s: %v", f.Name, err)
+		}
+	}
+
+	lines := errorLines
+	if len(lines) == 0 {
+		lines = lastLines
+	}
+	if len(lines) > maxLogSnippetLines {
+		lines = lines[len(lines)-maxLogSnippetLines:]
+	}
+	snippet := strings.Join(lines, "\n")
+	if len(snippet) > maxLogSnippetLength {
+		snippet = snippet[len(snippet)-maxLogSnippetLength:]
+	}
+	return snippet, nil
+}
+
+// trimLogTimestamp trims the timestamp prefixing each log line, if any.
+func trimLogTimestamp(line string) string {
+	split := strings.SplitN(line, " ", 2)
+	if len(split) == 2 {
+		if _, err := time.Parse(time.RFC3339Nano, split[0]); err == nil {
+			return split[1]
+		}
+	}
+	return line
+}
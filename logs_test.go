@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// testLogArchive returns a run log archive containing files, keyed by name.
+func testLogArchive(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractLogSnippet(t *testing.T) {
+	var manyErrors strings.Builder
+	for i := 0; i < maxLogSnippetLines+5; i++ {
+		fmt.Fprintf(&manyErrors, "##[error]error %d\n", i)
+	}
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name: "error lines",
+			files: map[string]string{
+				"1_build.txt":        "2021-01-01T00:00:00.0000000Z building\n2021-01-01T00:00:01.0000000Z ##[error]build failed\n",
+				"2_test.txt":         "##[error]test failed\ndone\n",
+				"build/1_Set up.txt": "##[error]step logs are not job logs\n",
+			},
+			want: "##[error]build failed\n##[error]test failed",
+		},
+		{
+			name:  "last lines of last job",
+			files: map[string]string{"1_build.txt": "built\n", "2_test.txt": "2021-01-01T00:00:00Z testing\nexit 1\n"},
+			want:  "testing\nexit 1",
+		},
+		{
+			name:  "bounded lines",
+			files: map[string]string{"1_build.txt": manyErrors.String()},
+			want:  strings.Join(strings.Split(strings.TrimSuffix(manyErrors.String(), "\n"), "\n")[5:], "\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippet, err := extractLogSnippet(testLogArchive(t, tt.files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snippet != tt.want {
+				t.Errorf("got snippet %q, want %q", snippet, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleCommentLogSnippet(t *testing.T) {
+	archive := testLogArchive(t, map[string]string{"1_build.txt": "2021-01-01T00:00:00.0000000Z ##[error]```boom\n"})
+	archiveServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer archiveServer.Close()
+
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+	api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+	api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+		map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+	api.handleReruns(10)
+	api.handlePage(http.MethodGet, "/repos/o/r/actions/runs/10/logs", http.StatusFound, nil,
+		http.Header{"Location": {archiveServer.URL + "/logs.zip"}})
+	api.handle(http.MethodPost, "/repos/o/r/issues/1/comments", http.StatusCreated, &github.IssueComment{})
+	h := newTestHandler(t, api)
+	h.logSnippets, h.postSummary = true, true
+
+	if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, "/rerun-all")); err != nil {
+		t.Fatal(err)
+	}
+	var reply github.IssueComment
+	api.body(t, http.MethodPost, "/repos/o/r/issues/1/comments", &reply)
+	want := "<details><summary>build run 10 logs</summary>\n\n````text\n##[error]```boom\n````\n</details>\n"
+	if !strings.Contains(reply.GetBody(), want) {
+		t.Errorf("got summary %q, want it to contain %q", reply.GetBody(), want)
+	}
+	if !api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun") {
+		t.Errorf("run was not rerun")
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"path"
 	"strconv"
@@ -11,14 +14,71 @@ import (
 )
 
 func main() {
+	validateConfig := flag.Bool("validate-config", false,
+		"Validate optional inputs set in the environment (INPUT_<NAME>), report any errors, and exit")
+	flag.Parse()
 
 	h := &handler{
 		Action: actions.New(),
 	}
 
+	if *validateConfig {
+		errs := h.loadInputs()
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "invalid input: %v\n", err)
+		}
+		if len(errs) != 0 {
+			os.Exit(1)
+		}
+		fmt.Println("All inputs are valid")
+		return
+	}
+
 	ctx := context.Background()
 	h.initFromActionsEnv(ctx)
 
+	repoOwner, repoName, warning, err := resolveOwnerRepo(os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_REPOSITORY_OWNER"))
+	if err != nil {
+		h.Fatalf("%v", err)
+	}
+	if warning != "" {
+		h.Warningf("%s", warning)
+	}
+
+	// Scheduled runs are not triggered by a comment.
+	if os.Getenv("GITHUB_EVENT_NAME") == "schedule" {
+		h.Debugf("Repo owner=%s name=%s scheduled", repoOwner, repoName)
+		if err := h.handleScheduled(ctx, repoOwner, repoName); err != nil {
+			h.Fatalf("%v", err)
+		}
+		return
+	}
+
+	// Commands in PR descriptions are run when the description is edited.
+	if event := os.Getenv("GITHUB_EVENT_NAME"); event == "pull_request" || event == "pull_request_target" {
+		if !h.descriptionCommands {
+			h.Fatalf("Triggered by a %s event, but description_commands is not set", event)
+		}
+		h.Debugf("Repo owner=%s name=%s PR description edited", repoOwner, repoName)
+		if err := h.handlePREdited(ctx, repoOwner, repoName); isRejection(err) {
+			h.Debugf("PR description ignored: %v", err)
+		} else if err != nil {
+			h.Fatalf("%v", err)
+		}
+		return
+	}
+
+	// Tools and maintainers can run commands without commenting by dispatching the workflow.
+	if os.Getenv("GITHUB_EVENT_NAME") == "workflow_dispatch" {
+		h.Debugf("Repo owner=%s name=%s dispatched", repoOwner, repoName)
+		if err := h.handleDispatch(ctx, repoOwner, repoName); isRejection(err) {
+			h.Debugf("Dispatched command ignored: %v", err)
+		} else if err != nil {
+			h.Fatalf("%v", err)
+		}
+		return
+	}
+
 	commentIDStr := h.GetInput("comment_id")
 	if commentIDStr == "" {
 		h.Fatalf("Empty comment_id")
@@ -27,16 +87,33 @@ func main() {
 	if err != nil {
 		h.Fatalf("Failed to parse comment_id: %v", err)
 	}
-
-	repo := os.Getenv("GITHUB_REPOSITORY")
-	if repo == "" {
-		h.Fatalf("GITHUB_REPOSITORY not set")
-	}
-	repoOwner, repoName := path.Split(repo)
-	repoOwner = strings.Trim(repoOwner, "/")
 	h.Debugf("Repo owner=%s name=%s commentID=%d", repoOwner, repoName, commentID)
 
-	if err := h.handle(ctx, repoOwner, repoName, commentID); err != nil {
+	if err := h.handle(ctx, repoOwner, repoName, commentID); isRejection(err) {
+		h.Debugf("Comment %d ignored: %v", commentID, err)
+	} else if err != nil {
 		h.Fatalf("%v", err)
 	}
 }
+
+// resolveOwnerRepo returns the owner and name of repoEnv, the "owner/name" value of GITHUB_REPOSITORY.
+// GITHUB_REPOSITORY_OWNER, ownerEnv, is set by Actions separately, so it is preferred over parsing if set;
+// if it does not match repoEnv's owner, the mismatch is described by warning.
+func resolveOwnerRepo(repoEnv, ownerEnv string) (owner, repo, warning string, err error) {
+	if repoEnv == "" {
+		return "", "", "", errors.New("GITHUB_REPOSITORY not set")
+	}
+	owner, repo = path.Split(repoEnv)
+	owner = strings.Trim(owner, "/")
+	if owner == "" || repo == "" || strings.Contains(owner, "/") {
+		return "", "", "", fmt.Errorf("GITHUB_REPOSITORY %q is not of the form owner/name", repoEnv)
+	}
+	if ownerEnv != "" {
+		if !strings.EqualFold(ownerEnv, owner) {
+			warning = fmt.Sprintf("GITHUB_REPOSITORY_OWNER %q does not match the owner in GITHUB_REPOSITORY %q, using %q",
+				ownerEnv, repoEnv, ownerEnv)
+		}
+		owner = ownerEnv
+	}
+	return owner, repo, warning, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestResolveOwnerRepo(t *testing.T) {
+	tests := []struct {
+		name        string
+		repoEnv     string
+		ownerEnv    string
+		wantOwner   string
+		wantRepo    string
+		wantWarning bool
+		wantErr     bool
+	}{
+		{name: "no owner env", repoEnv: "estroz/rerun-actions", wantOwner: "estroz", wantRepo: "rerun-actions"},
+		{name: "matching owner env", repoEnv: "estroz/rerun-actions", ownerEnv: "Estroz", wantOwner: "Estroz", wantRepo: "rerun-actions"},
+		{name: "mismatching owner env", repoEnv: "estroz/rerun-actions", ownerEnv: "other", wantOwner: "other", wantRepo: "rerun-actions", wantWarning: true},
+		{name: "unset", ownerEnv: "estroz", wantErr: true},
+		{name: "no owner", repoEnv: "rerun-actions", ownerEnv: "estroz", wantErr: true},
+		{name: "no name", repoEnv: "estroz/", wantErr: true},
+		{name: "nested", repoEnv: "estroz/rerun/actions", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, repo, warning, err := resolveOwnerRepo(tt.repoEnv, tt.ownerEnv)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, tt.wantErr)
+			}
+			if owner != tt.wantOwner || repo != tt.wantRepo {
+				t.Errorf("got %s/%s, want %s/%s", owner, repo, tt.wantOwner, tt.wantRepo)
+			}
+			if gotWarning := warning != ""; gotWarning != tt.wantWarning {
+				t.Errorf("got warning %q, want warning: %t", warning, tt.wantWarning)
+			}
+		})
+	}
+}
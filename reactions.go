@@ -0,0 +1,34 @@
+package main
+
+import "context"
+
+// Reactions to a triggering comment.
+const (
+	reactionReceived  = "eyes"
+	reactionSucceeded = "rocket"
+	reactionFailed    = "confused"
+)
+
+// addReaction reacts to the comment with commentID with content, returning the reaction's ID.
+func (h *handler) addReaction(ctx context.Context, repoOwner, repoName string, commentID int64, content string) (int64, error) {
+	reaction, _, err := h.Reactions.CreateIssueCommentReaction(ctx, repoOwner, repoName, commentID, content)
+	if err != nil {
+		return 0, err
+	}
+	return reaction.GetID(), nil
+}
+
+// finishReaction replaces the received reaction with receivedID on the comment with commentID
+// with a reaction indicating whether handling the comment succeeded.
+func (h *handler) finishReaction(ctx context.Context, repoOwner, repoName string, commentID, receivedID int64, succeeded bool) {
+	if _, err := h.Reactions.DeleteIssueCommentReaction(ctx, repoOwner, repoName, commentID, receivedID); err != nil {
+		h.Errorf("Failed to remove received reaction: %v", err)
+	}
+	content := reactionSucceeded
+	if !succeeded {
+		content = reactionFailed
+	}
+	if _, err := h.addReaction(ctx, repoOwner, repoName, commentID, content); err != nil {
+		h.Errorf("Failed to react to comment: %v", err)
+	}
+}
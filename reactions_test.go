@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v33/github"
+)
+
+const testReactionsPath = "/repos/o/r/issues/comments/100/reactions"
+
+// reactionContents returns the contents of reactions created on the test comment, in the order they were created.
+func reactionContents(t *testing.T, api *fakeAPI) (contents []string) {
+	for _, body := range api.requestBodies(http.MethodPost, testReactionsPath) {
+		var reaction github.Reaction
+		if err := json.Unmarshal([]byte(body), &reaction); err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, reaction.GetContent())
+	}
+	return contents
+}
+
+func TestHandleCommentReactionStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		rerunStatus int
+		want        []string
+	}{
+		{name: "succeeded", rerunStatus: http.StatusCreated, want: []string{reactionReceived, reactionSucceeded}},
+		{name: "failed", rerunStatus: http.StatusInternalServerError, want: []string{reactionReceived, reactionFailed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/rerun", tt.rerunStatus, nil)
+			api.handle(http.MethodPost, testReactionsPath, http.StatusCreated, &github.Reaction{ID: github.Int64(7)})
+			api.handle(http.MethodDelete, testReactionsPath+"/7", http.StatusNoContent, nil)
+			h := newTestHandler(t, api)
+			h.reactionStatus = true
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, "/rerun-all")); err != nil {
+				t.Fatal(err)
+			}
+			if got := reactionContents(t, api); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got reactions %v, want %v", got, tt.want)
+			}
+			if !api.called(http.MethodDelete, testReactionsPath+"/7") {
+				t.Errorf("received reaction was not removed")
+			}
+			if api.called(http.MethodPost, "/repos/o/r/issues/1/comments") {
+				t.Errorf("got a comment, want only reactions")
+			}
+		})
+	}
+}
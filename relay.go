@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// onBehalfOfPrefix starts the line of a trusted relay bot's comment naming the user it relays commands for,
+// ex. "on-behalf-of: @alice".
+const onBehalfOfPrefix = "on-behalf-of:"
+
+// parseOnBehalfOf returns the login named by body's on-behalf-of line and body without that line.
+// An empty login is returned if body has no such line. Relay bots may echo text users provide, so the line
+// is only honored as body's first line, and an error is returned if body has any other on-behalf-of line.
+func parseOnBehalfOf(body string) (login, rest string, err error) {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for i := 0; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(strings.ToLower(line), onBehalfOfPrefix) {
+			lines = append(lines, scanner.Text())
+			continue
+		}
+		if i != 0 {
+			return "", "", fmt.Errorf("%s line %d is not the first line", onBehalfOfPrefix, i+1)
+		}
+		login = strings.TrimPrefix(strings.TrimSpace(line[len(onBehalfOfPrefix):]), "@")
+	}
+	return login, strings.Join(lines, "\n"), nil
+}
+
+// relayComment returns comment, made by a trusted relay bot, as if made by the user it relays commands for.
+// That user's association is derived from their permission on the repo, since only commenters have one.
+// comment is returned unchanged if it does not name a user, and rejected if it may name one other than the first line's.
+func (h *handler) relayComment(ctx context.Context, repoOwner, repoName string, comment *github.IssueComment) (*github.IssueComment, error) {
+	login, body, err := parseOnBehalfOf(comment.GetBody())
+	if err != nil {
+		h.Warningf("Relay bot %s comment %d ignored: %v", comment.GetUser().GetLogin(), comment.GetID(), err)
+		return nil, errUnauthorized
+	}
+	if login == "" {
+		h.Debugf("Relay bot %s comment does not name a user", comment.GetUser().GetLogin())
+		return comment, nil
+	}
+	user, resp, err := h.Users.Get(ctx, login)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("relayed user %q does not exist", login)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get relayed user: %v", err)
+	}
+	perm, _, err := h.Repositories.GetPermissionLevel(ctx, repoOwner, repoName, login)
+	if err != nil {
+		return nil, fmt.Errorf("get relayed user permission: %v", err)
+	}
+	assoc := "NONE"
+	switch perm.GetPermission() {
+	case "admin", "write":
+		assoc = "COLLABORATOR"
+	}
+	h.Debugf("Relaying comment for %s (permission: %s)", login, perm.GetPermission())
+
+	relayed := *comment
+	relayed.Body = &body
+	relayed.User = user
+	relayed.AuthorAssociation = &assoc
+	return &relayed, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseOnBehalfOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantLogin string
+		wantRest  string
+		wantErr   bool
+	}{
+		{name: "no line", body: "/rerun-all", wantRest: "/rerun-all"},
+		{name: "first line", body: "on-behalf-of: @alice\n/rerun-all", wantLogin: "alice", wantRest: "/rerun-all"},
+		{name: "without @", body: "on-behalf-of: alice\n/rerun-all", wantLogin: "alice", wantRest: "/rerun-all"},
+		{name: "case and space", body: "  On-Behalf-Of:   @alice  \n/rerun-all", wantLogin: "alice", wantRest: "/rerun-all"},
+		{name: "not first line", body: "/rerun-all\non-behalf-of: @alice", wantErr: true},
+		{name: "echoed before attribution", body: "on-behalf-of: @admin\non-behalf-of: @alice\n/rerun-all", wantErr: true},
+		{name: "echoed after attribution", body: "on-behalf-of: @alice\n/rerun-all\non-behalf-of: @admin", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, rest, err := parseOnBehalfOf(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, tt.wantErr)
+			}
+			if login != tt.wantLogin || rest != tt.wantRest {
+				t.Errorf("got (%q, %q), want (%q, %q)", login, rest, tt.wantLogin, tt.wantRest)
+			}
+		})
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/v33/github"
 	actions "github.com/sethvargo/go-githubactions"
@@ -15,91 +18,749 @@ import (
 
 const (
 	testAll              = "__all"
+	testStack            = "__stack"
+	testMerge            = "__merge"
+	testGroupPrefix      = "__group:"
+	testRemoveLabel      = "__remove-label"
+	testCheckPrefix      = "__check:"
+	testBasePrefix       = "__base:"
+	testTagPrefix        = "__tag:"
+	testStale            = "__stale"
 	completedStatus      = "completed"
 	successfulConclusion = "success"
+	failureConclusion    = "failure"
+	skippedConclusion    = "skipped"
+	// actionRequired is the status or conclusion of a run waiting for a maintainer to approve it,
+	// ex. a first-time contributor's run.
+	actionRequired = "action_required"
+
+	activeState = "active"
+	// disabledManuallyState is the state of a workflow disabled by a maintainer, rather than by inactivity.
+	disabledManuallyState = "disabled_manually"
+
+	// getCommentAttempts bounds how many times fetching the triggering comment is tried,
+	// since the comment may not be readable immediately after the webhook fires.
+	getCommentAttempts = 3
+
+	// How runs that cannot be rerun yet, ex. while still being finalized, are handled.
+	notRerunnableFail  = "fail"
+	notRerunnableRetry = "retry"
+	notRerunnableSkip  = "skip"
+	// notRerunnableAttempts bounds how many times rerunning a run that cannot be rerun yet is tried.
+	notRerunnableAttempts = 3
+	notRerunnableBackoff  = 5 * time.Second
+
+	// maxCommentLength is the maximum length of a comment body.
+	maxCommentLength = 65536
+
+	// defaultRunEvent is the event whose runs are considered for reruns by default.
+	defaultRunEvent = "pull_request"
+
+	// defaultBotLogin is the login of the GITHUB_TOKEN's app, which this action comments as by default.
+	defaultBotLogin = "github-actions[bot]"
+
+	// actionsAppSlug is the slug of the app reporting check runs for Actions jobs.
+	actionsAppSlug = "github-actions"
+
+	// maxRunsPerPage is the largest page size the GitHub API allows.
+	maxRunsPerPage = 100
+
+	// Orders runs may be rerun in.
+	rerunOrderName    = "name"
+	rerunOrderPath    = "path"
+	rerunOrderID      = "id"
+	rerunOrderCreated = "created"
+
+	// Scopes of workflows that the rerun-all command expands to.
+	rerunAllScopeAll                  = "all"
+	rerunAllScopeRequired             = "required"
+	rerunAllScopeActiveNonBlocklisted = "active-nonblocklisted"
 
 	canTestLabel              = "ok-to-test"
 	retestAllWorkflowsCommand = "rerun-all"
 	testWorkflowCommand       = "rerun-workflow"
+	rerunStackCommand         = "rerun-stack"
+	rerunAndMergeCommand      = "rerun-and-merge"
+	rerunGroupCommand         = "rerun-group"
+	removeOkToTestCommand     = "remove-ok-to-test"
+	rerunCheckCommand         = "rerun-check"
+	rerunBaseCommand          = "rerun-base"
+	rerunTagCommand           = "rerun-tag"
+	rerunSkippedCommand       = "rerun-skipped"
+	rerunStaleCommand         = "rerun-stale"
+
+	// maxStackDepth bounds the number of PRs rerun by the rerun-stack command.
+	maxStackDepth = 5
+
+	// failedJobsOnlyFlag makes a command rerun only failed jobs of matched runs.
+	failedJobsOnlyFlag = "--failed-jobs-only"
+	// conclusionsFlag makes a command rerun only completed runs with one of the listed conclusions.
+	conclusionsFlag = "--conclusions"
 )
 
+// getCommentBackoff is multiplied by the attempt number to wait between attempts to fetch the triggering comment.
+// It is a variable so tests need not wait.
+var getCommentBackoff = 2 * time.Second
+
 type handler struct {
 	*github.Client
 	*actions.Action
+
+	// runsPerPage is the number of workflow runs requested per page. Zero uses the API default.
+	runsPerPage int
+	// verbose adds detail, such as available workflow names, to annotations.
+	verbose bool
+	// rerunAllScope is the set of workflows the rerun-all command expands to.
+	rerunAllScope string
+	// rerunOrder is the order runs are rerun in, one of the rerunOrder constants, or API order if empty.
+	rerunOrder string
+	// workflowBlocklist contains names of workflows excluded from the active-nonblocklisted scope.
+	workflowBlocklist map[string]struct{}
+	// postSummary enables commenting a summary of reruns on the PR.
+	postSummary bool
+	// attributeCommenter adds the triggering commenter's login to the summary.
+	attributeCommenter bool
+	// chunkSummary splits a summary too long for one comment into several comments instead of truncating it.
+	chunkSummary bool
+	// compactReply formats summaries as a single line instead of a table.
+	compactReply bool
+	// consolidateSummary keeps one status comment per PR, edited to show the latest summary, instead of
+	// commenting a summary per command.
+	consolidateSummary bool
+	// jsonEvent prints a JSON line describing each handled comment to stdout.
+	jsonEvent bool
+	// selectionReasons adds why each run was selected to the summary.
+	selectionReasons bool
+	// summaryCheckRun records the summary as a check run on the PR's head.
+	summaryCheckRun bool
+	// logSnippets adds an excerpt of each rerun's logs from before it was rerun to the summary.
+	logSnippets bool
+	// commentScanDepth bounds how many of a PR's most recent comments are scanned for the bot's comments.
+	// Zero scans all comments.
+	commentScanDepth int
+	// commentScanMaxAge bounds how old scanned comments may be. Zero scans comments of any age.
+	commentScanMaxAge time.Duration
+	// rerunStats adds cumulative rerun counts per commenter to the summary.
+	rerunStats bool
+	// minSettle rejects commands on PRs whose head was pushed less than this long ago.
+	minSettle time.Duration
+	// ignoreBeforeLastAction ignores comments created before this action last commented on the PR.
+	ignoreBeforeLastAction bool
+	// debounce is how long to wait for pushes to settle before reading the PR's head.
+	debounce time.Duration
+	// labelAssociations maps PR labels to the minimum author association allowed to run commands on those PRs.
+	labelAssociations map[string]string
+	// commandAssociations maps command names to the minimum author association allowed to run them.
+	commandAssociations map[string]string
+	// defaultMinAssociation is the minimum author association allowed to run commands on PRs without
+	// a label in labelAssociations. If empty, privileged associations are allowed.
+	defaultMinAssociation string
+	// scheduleLabel, if set, limits scheduled reruns to PRs with this label.
+	scheduleLabel string
+	// scheduleMaxPRs bounds the number of PRs a scheduled run reruns workflows for.
+	scheduleMaxPRs int
+	// earlyAck reacts to commands as soon as they are parsed, then with their outcome once handled.
+	earlyAck bool
+	// noticeSummary emits a notice annotation summarizing what a command did.
+	noticeSummary bool
+	// reactionStatus reports progress and outcome by reacting to the triggering comment instead of commenting.
+	reactionStatus bool
+	// requireOrgMembership only honors commands by members of the repo owner's org.
+	requireOrgMembership bool
+	// botLogin is the login this action comments as, whose comments are ignored unless botLoginDetected.
+	botLogin string
+	// botLoginDetected is true if botLogin is the owner of a personal access repo_token, who may post commands.
+	botLoginDetected bool
+	// trustedRelayBots contains logins of bots whose comments are handled as if made by the user they name.
+	trustedRelayBots map[string]struct{}
+	// firstTimerApproval requires commands by first-time contributors to be on PRs approved by a privileged reviewer.
+	firstTimerApproval bool
+	// orgMembers caches org membership by login.
+	orgMembers map[string]bool
+	// maintainersTeam, if set, is the "<org>/<team>" whose members are privileged, instead of privileged associations.
+	maintainersTeam string
+	// teamMembers caches maintainersTeam membership by login for teamMembershipTTL.
+	teamMembers map[string]teamMembership
+	// ignoreNoWorkflows silences the warning emitted when a repo has no active workflows.
+	ignoreNoWorkflows bool
+	// enableDisabledWorkflows enables manually disabled workflows selected by a privileged commenter's command
+	// so they can be rerun.
+	enableDisabledWorkflows bool
+	// rerunSuccessWithin is how long after succeeding a run may still be rerun. Zero never reruns successful runs.
+	rerunSuccessWithin time.Duration
+	// workflowCooldowns maps workflow names to how long after a rerun the workflow may be rerun again on a PR.
+	workflowCooldowns map[string]time.Duration
+	// rerunTimes records when workflows with cooldowns were last rerun on the PR being handled.
+	rerunTimes rerunTimes
+	// reportApprovalNeeded replies listing runs awaiting approval that the commenter cannot approve.
+	reportApprovalNeeded bool
+	// skipIfRequiredGreen skips reruns on PRs whose required status checks all passed.
+	skipIfRequiredGreen bool
+	// neverCancel disables cancelling runs that have not completed.
+	neverCancel bool
+	// waitIncomplete waits for runs that have not completed to complete, instead of skipping them,
+	// when runs cannot be cancelled.
+	waitIncomplete bool
+	// waitIncompleteDeadline is when waiting for runs that have not completed stops, set when the first is waited for
+	// so a command waits at most waitTimeout in total.
+	waitIncompleteDeadline time.Time
+	// notRerunnable is how runs that cannot be rerun yet are handled: fail, retry, or skip.
+	notRerunnable string
+	// cancelGrace is how long to let runs that have not completed finish before cancelling them.
+	cancelGrace time.Duration
+	// waitForCompletion waits for reruns to complete and reports their conclusions.
+	waitForCompletion bool
+	// failedJobLinks links reruns that did not succeed to their first failed job in the summary.
+	failedJobLinks bool
+	// waitTimeout bounds how long to wait for reruns to complete.
+	waitTimeout time.Duration
+	// mergeBranches are base branches whose PRs may be merged by the rerun-and-merge command.
+	mergeBranches map[string]struct{}
+	// runEvents are the events whose runs are considered for reruns.
+	runEvents map[string]struct{}
+	// excludeEvents are events whose runs are never considered for reruns.
+	excludeEvents map[string]struct{}
+	// sinceLastPush only considers runs created after the PR's latest push, rather than after the PR was opened.
+	sinceLastPush bool
+	// matchMergeRef matches runs triggered for a PR's merge ref as well as its head.
+	matchMergeRef bool
+	// workflowTagKey starts comments in workflow files listing the workflow's tags.
+	workflowTagKey string
+	// workflowTags caches the tags parsed from workflow files, by path.
+	workflowTags map[string][]string
+	// selfWorkflowPath is the path of the workflow running this action, which is never rerun.
+	selfWorkflowPath string
+	// workflowPaths are path prefixes of workflow files that may be rerun. If empty, all workflows may be rerun.
+	workflowPaths []string
+	// workflowGroups maps group names to member workflow names. Members ending in "*" match names by prefix.
+	workflowGroups map[string][]string
+	// descriptionCommands runs commands added to PR descriptions by edits.
+	descriptionCommands bool
+	// parser parses commands from comments.
+	parser commandParser
+
+	// inputErrs collects errors found while loading inputs.
+	inputErrs []error
 }
 
 // initFromActionsEnv initializes h from a GH Actions environment.
 func (h *handler) initFromActionsEnv(ctx context.Context) {
+	h.Client = github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: h.repoToken()},
+	)))
+
+	if errs := h.loadInputs(); len(errs) != 0 {
+		for _, err := range errs {
+			h.Errorf("%v", err)
+		}
+		h.Fatalf("Invalid inputs")
+	}
+	h.setBotLogin(ctx)
+}
+
+// repoToken returns the repo_token input, masked in logs.
+func (h *handler) repoToken() string {
 	token := h.GetInput("repo_token")
 	if token == "" {
 		h.Fatalf("Empty repo_token")
 	}
-	h.Client = github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)))
+	// The runner masks secrets passed from the secrets context, but not tokens passed otherwise,
+	// ex. read from a file in an earlier step, so mask it explicitly in case an error or debug log contains it.
+	h.AddMask(token)
+	return token
+}
+
+// setBotLogin sets h.botLogin to the login of the user owning the repo token, which this action comments as,
+// if bot_login is not set. App installation tokens, ex. GITHUB_TOKEN, cannot look up their user, so defaultBotLogin
+// is kept for them. A personal access token's owner may be a maintainer, so their comments are only ignored
+// if they contain botCommentMarker.
+func (h *handler) setBotLogin(ctx context.Context) {
+	user, _, err := h.Users.Get(ctx, "")
+	if err != nil {
+		h.Debugf("Token user not found, assuming %s: %v", h.botLogin, err)
+		return
+	}
+	h.Debugf("Token user is %s", user.GetLogin())
+	if h.GetInput("bot_login") != "" {
+		if user.GetLogin() == h.botLogin {
+			h.Warningf("bot_login %s owns repo_token, so commands posted by %s will be ignored", h.botLogin, h.botLogin)
+		}
+		return
+	}
+	h.botLogin = user.GetLogin()
+	h.botLoginDetected = true
 }
 
 // handle reruns a set of actions for the PR associated with a given commentID, if possible.
+// If the comment's commands are not run for an expected reason, ex. the commenter is unauthorized,
+// an error for which isRejection returns true is returned.
 func (h *handler) handle(ctx context.Context, repoOwner, repoName string, commentID int64) error {
-	comment, _, err := h.Issues.GetComment(ctx, repoOwner, repoName, commentID)
+	comment, err := h.getComment(ctx, repoOwner, repoName, commentID)
 	if err != nil {
 		h.Errorf("Failed to get comment: %v", err)
 		return nil
 	}
+	if comment == nil {
+		h.Debugf("Comment %d not found, it may have been deleted", commentID)
+		return nil
+	}
 	h.Debugf("Comment %d found", comment.GetID())
 
+	// Never run commands from this action's own comments. Comments by a detected personal access token's owner
+	// may be theirs, so only those containing botCommentMarker are ignored, by parseCommands.
+	if comment.GetUser().GetLogin() == h.botLogin && !h.botLoginDetected {
+		h.Debugf("Comment %d was posted by %s, ignoring", comment.GetID(), h.botLogin)
+		return errNoCommand
+	}
+
+	// Relay bots make comments for users who cannot comment themselves, ex. from chat.
+	if _, isRelay := h.trustedRelayBots[comment.GetUser().GetLogin()]; isRelay {
+		if comment, err = h.relayComment(ctx, repoOwner, repoName, comment); isRejection(err) {
+			return err
+		} else if err != nil {
+			h.Errorf("Failed to relay comment: %v", err)
+			return nil
+		}
+	}
+
+	return h.handleComment(ctx, repoOwner, repoName, comment)
+}
+
+// handleComment reruns a set of actions for the PR associated with comment, if possible.
+// Rejections are returned like handle. A comment without an ID, ex. one made from a PR description,
+// is not reacted to.
+func (h *handler) handleComment(ctx context.Context, repoOwner, repoName string, comment *github.IssueComment) (err error) {
 	// Reduce the number of API calls when a PR comment that does not contain a command is created
 	// by returning if no commands are present in the comment body.
-	testsToRerun := parseCommentsToWorkflowNames(comment.GetBody())
-	if len(testsToRerun) == 0 {
-		h.Debugf("No commands in comment body")
-		return nil
+	commands := h.parser.parseCommands(comment.GetBody())
+	h.setCommandsOutput(commands)
+	commands, rejectedCommands := h.authorizeCommands(commands, comment.GetAuthorAssociation())
+	testsToRerun := commandsToWorkflowNames(commands)
+	if len(testsToRerun) == 0 && len(rejectedCommands) == 0 {
+		h.Debugf("No commands in comment body: %q", commentSample(comment.GetBody()))
+		h.SetOutput("no_command", "true")
+		return errNoCommand
+	}
+	h.SetOutput("no_command", "false")
+	event := newCommandEvent(repoOwner, repoName, comment.GetUser().GetLogin(), append(commands, rejectedCommands...))
+	if h.jsonEvent {
+		defer func() { h.emitEvent(event, err) }()
+	}
+
+	// Acknowledge the comment before any API calls that may take a while, ex. paging through runs.
+	// Commands that are rejected or fail are reacted to as failed.
+	succeeded := false
+	if h.earlyAck && comment.GetID() != 0 {
+		// The deferred reaction depends on the error handling returns, so do not shadow it.
+		receivedID, reactErr := h.addReaction(ctx, repoOwner, repoName, comment.GetID(), reactionReceived)
+		if reactErr != nil {
+			h.Errorf("Failed to react to comment: %v", reactErr)
+		} else {
+			defer func() {
+				h.finishReaction(ctx, repoOwner, repoName, comment.GetID(), receivedID, succeeded && err == nil)
+			}()
+		}
 	}
 
 	issue, _, err := h.getIssueForComment(ctx, comment)
 	if err != nil {
 		h.Errorf("Failed to get issue: %v", err)
+		event.setErrored("get issue", err)
 		return nil
 	}
 	h.Debugf("Issue %d found", issue.GetID())
 
 	// Actions associated with non-PR issues and locked PRs cannot be rerun.
-	if !isIssueRerunable(issue) {
-		h.Debugf("Issue is not a PR or is locked")
-		return nil
+	if err := checkIssueRerunable(issue); err != nil {
+		h.Debugf("Issue cannot be rerun: %v", err)
+		return err
+	}
+
+	// Make sure the issue fetched is the one commented on, so commands never act on another PR.
+	if num, ok := issueURLNumber(comment.GetIssueURL()); !ok || num != issue.GetNumber() {
+		h.Warningf("Comment issue URL %s does not match issue %d, ignoring commands", comment.GetIssueURL(), issue.GetNumber())
+		return errIssueMismatch
 	}
 
 	// Issue must have "ok-to-test" label, or the issue commenter must have org/repo permissions to run tests.
-	if !hasOkToTestLabel(issue) && !isCommenterPrivileged(comment.GetAuthorAssociation()) {
-		h.Debugf("Issue lacks the \"ok-to-test\" label (labels: %v) and commenter is unprivileged (association: %s)",
+	commenterPrivileged := isCommenterPrivileged(comment.GetAuthorAssociation())
+	authorized := hasOkToTestLabel(issue) || h.isCommenterAuthorized(issue, comment.GetAuthorAssociation())
+	// A maintainers team replaces associations, so maintainers are managed in GitHub rather than config.
+	if h.maintainersTeam != "" {
+		isMaintainer, err := h.isTeamMember(ctx, comment.GetUser().GetLogin())
+		if err != nil {
+			h.Errorf("Failed to check %s team membership: %v", h.maintainersTeam, err)
+			event.setErrored(fmt.Sprintf("check %s team membership", h.maintainersTeam), err)
+			return nil
+		}
+		commenterPrivileged = isMaintainer
+		authorized = hasOkToTestLabel(issue) || isMaintainer
+	}
+	if !authorized {
+		h.Debugf("Issue lacks the \"ok-to-test\" label (labels: %v) and commenter is unauthorized (association: %s)",
 			issue.Labels, comment.GetAuthorAssociation())
-		return nil
+		return errUnauthorized
+	}
+
+	// Some orgs exclude outside collaborators, who otherwise look privileged.
+	if h.requireOrgMembership {
+		login := comment.GetUser().GetLogin()
+		isMember, err := h.isOrgMember(ctx, repoOwner, login)
+		if err != nil {
+			h.Errorf("Failed to check org membership: %v", err)
+			event.setErrored("check org membership", err)
+			return nil
+		}
+		if !isMember {
+			h.Debugf("Commenter %s is not a member of org %s", login, repoOwner)
+			return errUnauthorized
+		}
 	}
 
 	prNum := issue.GetNumber()
+	event.PR = prNum
+
+	// Commands this action already acted on, or that predate its last action, should not be replayed.
+	if h.ignoreBeforeLastAction && !comment.GetCreatedAt().IsZero() {
+		lastAction, err := h.getLastActionTime(ctx, repoOwner, repoName, prNum)
+		if err != nil {
+			h.Errorf("Failed to get last action time: %v", err)
+			event.setErrored("get last action time", err)
+			return nil
+		}
+		if comment.GetCreatedAt().Before(lastAction) {
+			h.Debugf("Comment was created at %s, before the last action on PR %d at %s",
+				comment.GetCreatedAt().Format(time.RFC3339), prNum, lastAction.Format(time.RFC3339))
+			return errStaleComment
+		}
+	}
+	// First-time contributors may be held to a stricter standard than the PR's labels allow.
+	if h.firstTimerApproval && isFirstTimer(comment.GetAuthorAssociation()) {
+		approved, err := h.hasPrivilegedApproval(ctx, repoOwner, repoName, prNum)
+		if err != nil {
+			h.Errorf("Failed to check PR approval: %v", err)
+			event.setErrored("check PR approval", err)
+			return nil
+		}
+		if !approved {
+			h.Debugf("Commenter is a first-timer (association: %s) and PR %d has no approval by a privileged reviewer",
+				comment.GetAuthorAssociation(), prNum)
+			return errUnauthorized
+		}
+	}
+
 	pr, _, err := h.PullRequests.Get(ctx, repoOwner, repoName, prNum)
 	if err != nil {
 		h.Errorf("Failed to get PR: %v", err)
+		event.setErrored("get PR", err)
 		return nil
 	}
+	if pr.GetNumber() != prNum {
+		h.Warningf("PR %d fetched for issue %d, ignoring commands", pr.GetNumber(), prNum)
+		return errIssueMismatch
+	}
+
+	// Commands issued right after several quick pushes should apply to the final head,
+	// so wait out the pushes and reread the PR.
+	if h.debounce > 0 {
+		h.Debugf("Waiting %s before reading PR %d head", h.debounce, prNum)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(h.debounce):
+		}
+		if pr, _, err = h.PullRequests.Get(ctx, repoOwner, repoName, prNum); err != nil {
+			h.Errorf("Failed to get PR: %v", err)
+			event.setErrored("get PR", err)
+			return nil
+		}
+	}
 
 	// Can't rerun actions on merged PRs.
 	if pr.GetMerged() {
 		h.Debugf("PR has been merged, cannot rerun workflows")
+		return errMerged
+	}
+
+	// A PR whose fork was deleted has no head repo to match runs against.
+	if pr.GetHead().GetRepo() == nil {
+		h.Debugf("PR %d head repo was deleted", prNum)
+		h.reportHeadRepoDeleted(ctx, repoOwner, repoName, prNum)
+		return errHeadRepoDeleted
+	}
+
+	// Runs of a fresh push may not have been created yet, so commands would act on the previous push's runs.
+	if h.minSettle > 0 {
+		committed, err := h.headCommitTime(ctx, repoOwner, repoName, pr)
+		if err != nil {
+			h.Errorf("Failed to get PR head commit: %v", err)
+			event.setErrored("get PR head commit", err)
+			return nil
+		}
+		if settled := committed.Add(h.minSettle); time.Now().Before(settled) {
+			h.reportSettling(ctx, repoOwner, repoName, prNum, settled)
+			return errSettling
+		}
+	}
+
+	// Commands disallowed for the commenter are reported, while the rest of the comment is still honored.
+	if len(rejectedCommands) != 0 {
+		h.reportRejectedCommands(ctx, repoOwner, repoName, prNum, rejectedCommands, comment.GetAuthorAssociation())
+	}
+	if len(testsToRerun) == 0 {
+		return errUnauthorized
+	}
+
+	// Acknowledge the comment, then replace the acknowledgement with the outcome once handled.
+	// An early acknowledgement already does so.
+	if h.reactionStatus && !h.earlyAck && comment.GetID() != 0 {
+		receivedID, err := h.addReaction(ctx, repoOwner, repoName, comment.GetID(), reactionReceived)
+		if err != nil {
+			h.Errorf("Failed to react to comment: %v", err)
+		} else {
+			defer func() {
+				h.finishReaction(ctx, repoOwner, repoName, comment.GetID(), receivedID, succeeded)
+			}()
+		}
+	}
+
+	if _, removeLabel := testsToRerun[testRemoveLabel]; removeLabel {
+		delete(testsToRerun, testRemoveLabel)
+		// Removing the label re-gates the PR, ex. after a contributor pushes new code, so only privileged
+		// commenters may do so.
+		if !commenterPrivileged {
+			h.Debugf("Commenter is unprivileged (association: %s), cannot remove %q label",
+				comment.GetAuthorAssociation(), canTestLabel)
+		} else if err := h.removeLabel(ctx, repoOwner, repoName, issue, canTestLabel); err != nil {
+			h.Errorf("Failed to remove %q label: %v", canTestLabel, err)
+		}
+		if len(testsToRerun) == 0 {
+			return nil
+		}
+	}
+
+	// Reruns on the base branch affect more than this PR, so only privileged commenters may trigger them.
+	baseWorkflows := splitBaseWorkflows(testsToRerun)
+	if len(baseWorkflows) != 0 && !commenterPrivileged {
+		h.Debugf("Commenter is unprivileged (association: %s), cannot rerun base branch workflows",
+			comment.GetAuthorAssociation())
+		baseWorkflows = nil
+	}
+	if len(testsToRerun) == 0 && len(baseWorkflows) == 0 {
 		return nil
 	}
 
+	prs := []*github.PullRequest{pr}
+	if stackOpts, rerunStack := testsToRerun[testStack]; rerunStack {
+		delete(testsToRerun, testStack)
+		// Reruns on other PRs must be authorized by more than this PR's "ok-to-test" label.
+		if !commenterPrivileged {
+			h.Debugf("Commenter is unprivileged (association: %s), cannot rerun PR stack", comment.GetAuthorAssociation())
+		} else {
+			if prs, err = h.getPRStack(ctx, repoOwner, repoName, pr); err != nil {
+				h.Errorf("Failed to get PR stack: %v", err)
+				event.setErrored("get PR stack", err)
+				return nil
+			}
+			h.Debugf("Rerunning all workflows for a stack of %d PRs", len(prs))
+			if _, rerunAll := testsToRerun[testAll]; !rerunAll {
+				testsToRerun[testAll] = stackOpts
+			}
+		}
+		if len(testsToRerun) == 0 && len(baseWorkflows) == 0 {
+			return nil
+		}
+	}
+
+	mergeOpts, rerunAndMerge := testsToRerun[testMerge]
+	if rerunAndMerge {
+		delete(testsToRerun, testMerge)
+		if !commenterPrivileged {
+			h.Debugf("Commenter is unprivileged (association: %s), cannot merge", comment.GetAuthorAssociation())
+			rerunAndMerge = false
+		} else if _, canMerge := h.mergeBranches[pr.GetBase().GetRef()]; !canMerge {
+			h.Debugf("PR base branch %s does not allow merging by command", pr.GetBase().GetRef())
+			rerunAndMerge = false
+		} else if _, rerunAll := testsToRerun[testAll]; !rerunAll {
+			testsToRerun[testAll] = mergeOpts
+		}
+		if len(testsToRerun) == 0 && len(baseWorkflows) == 0 {
+			return nil
+		}
+	}
+
+	// Nothing needs rerunning if every check blocking the merge passed.
+	if h.skipIfRequiredGreen {
+		green, err := h.areRequiredChecksGreen(ctx, repoOwner, repoName, pr)
+		if err != nil {
+			h.Errorf("Failed to check required status checks: %v", err)
+			event.setErrored("check required status checks", err)
+			return nil
+		}
+		if green {
+			h.reportRequiredChecksGreen(ctx, repoOwner, repoName, prNum)
+			return nil
+		}
+	}
+
+	if len(h.workflowCooldowns) != 0 {
+		if h.rerunTimes, err = h.getRerunTimes(ctx, repoOwner, repoName, prNum); err != nil {
+			h.Errorf("Failed to get rerun times: %v", err)
+			h.rerunTimes = rerunTimes{}
+		}
+	}
+	var results []rerunResult
+	for _, pr := range prs {
+		if len(testsToRerun) == 0 {
+			break
+		}
+		if pr.GetHead().GetRepo() == nil {
+			h.Debugf("PR %d head repo was deleted, skipping", pr.GetNumber())
+			continue
+		}
+		prResults, err := h.rerunPRWorkflows(ctx, repoOwner, repoName, pr, testsToRerun, commenterPrivileged)
+		if err == errNoActiveWorkflows {
+			h.reportNoActiveWorkflows(ctx, repoOwner, repoName, prNum)
+			return nil
+		}
+		if err != nil {
+			h.Errorf("Failed to rerun PR %d workflows: %v", pr.GetNumber(), err)
+			event.setErrored(fmt.Sprintf("rerun PR %d workflows", pr.GetNumber()), err)
+			return nil
+		}
+		results = append(results, prResults...)
+	}
+	if len(baseWorkflows) != 0 {
+		baseResults, err := h.rerunBaseWorkflows(ctx, repoOwner, repoName, pr, baseWorkflows)
+		if err != nil {
+			h.Errorf("Failed to rerun base branch workflows: %v", err)
+			event.setErrored("rerun base branch workflows", err)
+		}
+		results = append(results, baseResults...)
+	}
+	if hasOutcome(results, outcomePermissionDenied) {
+		h.reportPermissionDenied(ctx, repoOwner, repoName, prNum)
+	}
+	if h.reportApprovalNeeded && !commenterPrivileged {
+		h.reportAwaitingApproval(ctx, repoOwner, repoName, prNum, comment.GetUser().GetLogin(), results)
+	}
+	if h.waitForCompletion || rerunAndMerge {
+		if err := h.waitForReruns(ctx, repoOwner, repoName, results); err != nil {
+			h.Errorf("Failed waiting for reruns to complete: %v", err)
+			event.setErrored("wait for reruns", err)
+		}
+	}
+	succeeded = !anyFailed(results)
+	h.setResultsOutput(results)
+	event.setResults(results)
+	if h.noticeSummary {
+		h.notice(noticeSummary(prNum, results))
+	}
+
+	if rerunAndMerge {
+		if err := h.mergeIfGreen(ctx, repoOwner, repoName, pr, results); err != nil {
+			h.Errorf("Failed to merge PR: %v", err)
+			succeeded = false
+			event.Outcome = eventFailed
+		}
+	}
+
+	if h.summaryCheckRun {
+		sum := summary{results: results, triggeredBy: comment.GetUser().GetLogin(), reasons: commandReasons(commands),
+			selections: h.selectionReasons}
+		if err := h.createSummaryCheckRun(ctx, repoOwner, repoName, pr.GetHead().GetSHA(), sum); err != nil {
+			h.Errorf("Failed to create summary check run: %v", err)
+			event.setErrored("create summary check run", err)
+		}
+	}
+
+	if h.postSummary {
+		sum := summary{results: results, reasons: commandReasons(commands), compact: h.compactReply,
+			selections: h.selectionReasons}
+		if h.rerunTimes != nil {
+			h.recordRerunTimes(results)
+			sum.rerunTimes = h.rerunTimes
+		}
+		if h.attributeCommenter {
+			sum.triggeredBy = comment.GetUser().GetLogin()
+		}
+		if h.rerunStats {
+			if sum.stats, err = h.getRerunStats(ctx, repoOwner, repoName, prNum); err != nil {
+				h.Errorf("Failed to get rerun stats: %v", err)
+				sum.stats = rerunStats{}
+			}
+			for _, result := range results {
+				if result.outcome == outcomeRerun {
+					sum.stats[comment.GetUser().GetLogin()]++
+				}
+			}
+		}
+		if h.consolidateSummary {
+			entry := newStatusEntry(comment.GetUser().GetLogin(), commands, results)
+			if err := h.updateStatusComment(ctx, repoOwner, repoName, prNum, sum, entry); err != nil {
+				h.Errorf("Failed to update status comment: %v", err)
+				event.setErrored("update status comment", err)
+			}
+			return nil
+		}
+		for _, body := range sum.comments(maxCommentLength, h.chunkSummary) {
+			if err := h.createComment(ctx, repoOwner, repoName, prNum, body); err != nil {
+				h.Errorf("Failed to post summary: %v", err)
+				event.setErrored("post summary", err)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// rerunPRWorkflows reruns pr's runs of workflows selected by testsToRerun, returning what was done with each run.
+func (h *handler) rerunPRWorkflows(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest,
+	testsToRerun map[string]rerunOptions, commenterPrivileged bool) ([]rerunResult, error) {
 	opts := &github.ListOptions{}
 	allWorkflows, _, err := h.Actions.ListWorkflows(ctx, repoOwner, repoName, opts)
 	if err != nil {
-		h.Errorf("Failed to list workflows: %v", err)
-		return nil
+		return nil, fmt.Errorf("list workflows: %v", err)
 	}
+	if !h.hasActiveWorkflow(allWorkflows.Workflows, commenterPrivileged) {
+		return nil, errNoActiveWorkflows
+	}
+	// Checks select one workflow each, so they take precedence over groups, though not over named workflows.
+	if testsToRerun, err = h.expandChecks(ctx, repoOwner, repoName, pr, testsToRerun, allWorkflows.Workflows); err != nil {
+		return nil, err
+	}
+	testsToRerun = h.expandWorkflowGroups(testsToRerun, allWorkflows.Workflows)
+	if testsToRerun, err = h.expandWorkflowTags(ctx, repoOwner, repoName, testsToRerun, allWorkflows.Workflows); err != nil {
+		return nil, err
+	}
+	// Stale workflows have no run on the head to rerun, so they are dispatched once others are handled.
+	_, dispatchStale := testsToRerun[testStale]
+	delete(testsToRerun, testStale)
 
 	var workflows []*github.Workflow
+	// requiredChecks is non-nil only if rerun-all should be limited to required workflows.
+	var requiredChecks map[string]struct{}
 	if _, rerunAll := testsToRerun[testAll]; rerunAll {
-		h.Debugf("Rerunning all workflows")
-		workflows = allWorkflows.Workflows
+		h.Debugf("Rerunning all workflows (scope: %s)", h.rerunAllScope)
+		switch h.rerunAllScope {
+		case rerunAllScopeRequired:
+			requiredChecks, err = h.getRequiredChecks(ctx, repoOwner, repoName, pr.GetBase().GetRef())
+			if err != nil {
+				return nil, fmt.Errorf("get required status checks: %v", err)
+			}
+			workflows = allWorkflows.Workflows
+		case rerunAllScopeActiveNonBlocklisted:
+			for _, workflow := range allWorkflows.Workflows {
+				if _, blocked := h.workflowBlocklist[workflow.GetName()]; blocked {
+					h.Debugf("Workflow %s is blocklisted", workflow.GetName())
+					continue
+				}
+				workflows = append(workflows, workflow)
+			}
+		default:
+			workflows = allWorkflows.Workflows
+		}
 	} else {
 		for _, workflow := range allWorkflows.Workflows {
 			if _, hasWorkflow := testsToRerun[workflow.GetName()]; !hasWorkflow {
@@ -109,110 +770,975 @@ func (h *handler) handle(ctx context.Context, repoOwner, repoName string, commen
 			h.Debugf("Workflow %s found", workflow.GetName())
 			workflows = append(workflows, workflow)
 		}
+		h.warnUnmatchedWorkflows(testsToRerun, allWorkflows.Workflows)
+	}
+
+	targets := make([]string, 0, len(workflows))
+	for _, workflow := range workflows {
+		targets = append(targets, workflow.GetName())
+	}
+	h.Debugf("Resolved PR %d targets: %s", pr.GetNumber(), strings.Join(targets, ", "))
+
+	since, err := h.runScanStart(ctx, repoOwner, repoName, pr)
+	if err != nil {
+		return nil, err
 	}
 
 	var runsToRerun []*github.WorkflowRun
 	for _, workflow := range workflows {
 		h.Debugf("Workflow name: %s (%s)", workflow.GetName(), workflow.GetPath())
 		// Always skip this workflow to prevent recursion issues.
-		if wfName := os.Getenv("GITHUB_WORKFLOW"); wfName == workflow.GetName() || wfName == workflow.GetPath() {
+		if h.isSelfWorkflow(workflow) {
 			h.Debugf("Skipping the workflow containing this job")
 			continue
 		}
-		// Do not attempt to rerun inactive workflows.
-		if workflow.GetState() != "active" {
+		if !h.isWorkflowPathAllowed(workflow.GetPath()) {
+			// Tell commenters why a workflow they named explicitly was not rerun.
+			if _, named := testsToRerun[workflow.GetName()]; named {
+				h.Warningf("Workflow %s path %s is not in workflow_paths, will not rerun", workflow.GetName(), workflow.GetPath())
+			} else {
+				h.Debugf("Skipping workflow outside of workflow_paths")
+			}
+			continue
+		}
+		// Do not attempt to rerun inactive workflows, unless a maintainer may re-enable them.
+		if workflow.GetState() == disabledManuallyState && h.enableDisabledWorkflows && commenterPrivileged {
+			if _, err := h.Actions.EnableWorkflowByID(ctx, repoOwner, repoName, workflow.GetID()); err != nil {
+				h.Errorf("Failed to enable workflow %s: %v", workflow.GetName(), err)
+				continue
+			}
+			h.Debugf("Enabled manually disabled workflow")
+		} else if workflow.GetState() != activeState {
 			h.Debugf("Skipping inactive workflow")
 			continue
 		}
 		opts := &github.ListWorkflowRunsOptions{
 			// Filter by whoever created the PR.
-			Actor: issue.GetUser().GetLogin(),
-			// Filter on pull request runs.
-			Event: "pull_request",
+			Actor: pr.GetUser().GetLogin(),
+			// Filter on pull request runs, or on runs of any event if several are matched.
+			Event:       h.runEventQuery(),
+			ListOptions: github.ListOptions{PerPage: h.runsPerPage},
 		}
-		// TODO: paginate
-		workflowRuns, _, err := h.Actions.ListWorkflowRunsByID(ctx, repoOwner, repoName, workflow.GetID(), opts)
+		run, err := h.findPRRun(ctx, repoOwner, repoName, workflow.GetID(), pr, since, opts)
 		if err != nil {
-			h.Errorf("Failed to list workflow runs: %v", err)
-			return nil
+			return nil, fmt.Errorf("list workflow runs: %v", err)
 		}
-		for _, run := range workflowRuns.WorkflowRuns {
-			// Stop searching runs once an older run is found.
-			if run.GetCreatedAt().Before(pr.GetCreatedAt()) {
-				h.Debugf("Older workflow run than PR %d found", prNum)
-				break
+		if run == nil {
+			continue
+		}
+		if requiredChecks != nil {
+			isRequired, err := h.isRunRequired(ctx, repoOwner, repoName, run, requiredChecks)
+			if err != nil {
+				return nil, fmt.Errorf("list workflow run jobs: %v", err)
 			}
-			// A matching run's SHA will match the PR's head SHA.
-			if run.GetHeadSHA() == pr.GetHead().GetSHA() {
-				h.Debugf("Found run matching PR %d SHA %s", prNum, pr.GetHead().GetSHA())
-				runsToRerun = append(runsToRerun, run)
-				break
+			if !isRequired {
+				h.Debugf("Workflow run %d has no required checks", run.GetID())
+				continue
 			}
 		}
+		runsToRerun = append(runsToRerun, run)
+	}
+
+	workflowNames := make(map[int64]string, len(workflows))
+	for _, workflow := range workflows {
+		workflowNames[workflow.GetID()] = workflow.GetName()
+	}
+	if h.rerunOrder != "" {
+		h.sortRuns(runsToRerun, workflows)
 	}
 
+	// All runs share one grace period, so it is waited out at most once.
+	var graceEnd time.Time
+	results := make([]rerunResult, 0, len(runsToRerun))
 	for _, run := range runsToRerun {
-		if run.GetStatus() == completedStatus && run.GetConclusion() == successfulConclusion {
+		result := rerunResult{prNum: pr.GetNumber(), workflowName: workflowNames[run.GetWorkflowID()], run: run}
+		result.selections = []string{selectionHeadSHA}
+		if run.GetHeadSHA() != pr.GetHead().GetSHA() {
+			result.selections = []string{selectionMergeRefSHA}
+		}
+		if requiredChecks != nil {
+			result.selections = append(result.selections, selectionRequiredCheck)
+		}
+		rerunOpts := optionsForWorkflow(testsToRerun, result.workflowName)
+		if isAwaitingApproval(run) {
+			// Runs that never started must be approved rather than rerun, which only privileged commenters may do.
+			if !commenterPrivileged {
+				h.Debugf("Workflow run %d is awaiting approval, commenter cannot approve", run.GetID())
+				result.outcome = outcomeAwaitingApproval
+			} else if _, err := h.approve(ctx, repoOwner, repoName, run.GetID()); err != nil {
+				h.Errorf("Failed to approve workflow run: %v", err)
+				result.outcome, result.err = outcomeApproveFailed, describeError(err)
+			} else {
+				h.Debugf("Approved workflow run %d", run.GetID())
+				result.outcome = outcomeApproved
+			}
+			results = append(results, result)
+			continue
+		}
+		if run.GetStatus() != completedStatus && !rerunOpts.skipIncomplete && h.neverCancel && h.waitIncomplete {
+			// Let the run finish, then handle it like any other completed run.
+			h.Debugf("Waiting for %s run %d to complete", run.GetStatus(), run.GetID())
+			if h.waitIncompleteDeadline.IsZero() {
+				h.waitIncompleteDeadline = time.Now().Add(h.waitTimeout)
+			}
+			completedRun, err := h.waitForRun(ctx, repoOwner, repoName, run.GetID(), h.waitIncompleteDeadline)
+			if err != nil {
+				h.Errorf("Failed waiting for workflow run to complete: %v", err)
+			} else {
+				run, result.run = completedRun, completedRun
+			}
+		}
+		if run.GetStatus() != completedStatus && !rerunOpts.skipIncomplete && !h.neverCancel && h.cancelGrace > 0 {
+			// Give a run that may be about to complete a chance to, then handle it by its fresh status.
+			if graceEnd.IsZero() {
+				graceEnd = time.Now().Add(h.cancelGrace)
+			}
+			if freshRun, err := h.awaitCancelGrace(ctx, repoOwner, repoName, run.GetID(), graceEnd); err != nil {
+				h.Debugf("Failed to get workflow run %d after cancel grace period: %v", run.GetID(), err)
+			} else {
+				run, result.run = freshRun, freshRun
+			}
+		}
+		if run.GetStatus() == completedStatus && run.GetConclusion() == successfulConclusion &&
+			!h.isRecentSuccess(run) {
 			// Skip runs that have completed and succeeded, since they cannot be re-run.
 			// This is still being worked on server-side afaik.
 			h.Debugf("Workflow run %d succeeded, will not rerun", run.GetID())
+			result.outcome = outcomeSkippedSucceeded
+			results = append(results, result)
+			continue
+		}
+		if run.GetConclusion() == successfulConclusion {
+			result.selections = append(result.selections, selectionRecentSuccess)
+		}
+		if rerunOpts.conclusions != nil {
+			if _, selected := rerunOpts.conclusions[run.GetConclusion()]; !selected || run.GetStatus() != completedStatus {
+				h.Debugf("Workflow run %d is %s (%s), not a selected conclusion", run.GetID(), run.GetStatus(), run.GetConclusion())
+				result.outcome = outcomeSkippedConclusion
+				results = append(results, result)
+				continue
+			}
+		}
+		if rerunOpts.dispatchSkipped && run.GetConclusion() == skippedConclusion {
+			result.outcome = h.dispatchOnHead(ctx, repoOwner, repoName, pr, run, commenterPrivileged)
+			results = append(results, result)
+			continue
+		}
+		if end, onCooldown := h.cooldownEnd(result.workflowName); onCooldown {
+			h.Warningf("Workflow %s is on cooldown until %s, will not rerun", result.workflowName, end.Format(time.RFC3339))
+			result.outcome = fmt.Sprintf(outcomeSkippedCooldown, end.Format(time.RFC3339))
+			results = append(results, result)
+			continue
+		}
+		if run.GetStatus() != completedStatus && (rerunOpts.skipIncomplete || h.neverCancel) {
+			h.Debugf("Workflow run %d is %s, will not cancel", run.GetID(), run.GetStatus())
+			result.outcome = outcomeSkippedIncomplete
+			results = append(results, result)
 			continue
 		}
 		if run.GetStatus() != completedStatus {
 			// Cancel non-completed runs before queuing a rerun.
 			h.Debugf("Cancellling %s run %v", run.GetStatus(), run.GetID())
 			_, err := h.Actions.CancelWorkflowRunByID(ctx, repoOwner, repoName, run.GetID())
+			if isPermissionDenied(err) {
+				h.Errorf("Failed to cancel workflow run: %v", err)
+				result.outcome, result.err = outcomePermissionDenied, describeError(err)
+				results = append(results, result)
+				continue
+			}
 			if err != nil {
 				h.Debugf("Failed to cancel workflow run: %v", err)
 			}
 		}
 
-		h.Debugf("Rerunning %d", run.GetID())
-		_, err := h.Actions.RerunWorkflowByID(ctx, repoOwner, repoName, run.GetID())
-		if err != nil {
+		// Logs are replaced by the rerun's, so excerpt them first.
+		if h.logSnippets && run.GetStatus() == completedStatus {
+			if result.logSnippet, err = h.getLogSnippet(ctx, repoOwner, repoName, run.GetID()); err != nil {
+				h.Debugf("Failed to get workflow run %d log snippet: %v", run.GetID(), err)
+			}
+		}
+		h.Debugf("Rerunning %d (failed jobs only: %t)", run.GetID(), rerunOpts.failedJobsOnly)
+		err := h.rerunWhenRerunnable(ctx, repoOwner, repoName, run.GetID(), rerunOpts)
+		switch {
+		case err == errNotRerunnableYet:
+			h.Warningf("Workflow run %d cannot be rerun yet, will not rerun", run.GetID())
+			result.outcome = outcomeSkippedNotRerunnable
+		case isPermissionDenied(err):
 			h.Errorf("Failed to rerun workflow: %v", err)
+			result.outcome, result.err = outcomePermissionDenied, describeError(err)
+		case err != nil:
+			h.Errorf("Failed to rerun workflow: %v", err)
+			result.outcome, result.err = outcomeRerunFailed, describeError(err)
+		default:
+			result.outcome = outcomeRerun
 		}
+		results = append(results, result)
 	}
 
-	return nil
+	if dispatchStale {
+		staleResults, err := h.dispatchStaleWorkflows(ctx, repoOwner, repoName, pr, allWorkflows.Workflows, commenterPrivileged)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, staleResults...)
+	}
+	return results, nil
 }
 
-func (h *handler) getIssueForComment(ctx context.Context, comment *github.IssueComment) (issue *github.Issue, resp *github.Response, err error) {
-	h.Debugf("Issue URL: %s", comment.GetIssueURL())
-	req, err := h.NewRequest(http.MethodGet, comment.GetIssueURL(), nil)
-	if err != nil {
-		return nil, nil, fmt.Errorf("create request: %v", err)
-	}
-	issue = &github.Issue{}
-	if resp, err = h.Do(ctx, req, issue); err != nil {
-		return nil, resp, fmt.Errorf("do request: %v", err)
+// Reasons handle does not run a comment's commands. These are expected outcomes rather than failures.
+var (
+	errNoCommand       = errors.New("no commands in comment body")
+	errNotPullRequest  = errors.New("issue is not a PR")
+	errLocked          = errors.New("PR is locked")
+	errUnauthorized    = errors.New("commenter is unauthorized")
+	errMerged          = errors.New("PR has been merged")
+	errHeadRepoDeleted = errors.New("PR head repo was deleted")
+	errIssueMismatch   = errors.New("issue does not match the commented PR")
+	errSettling        = errors.New("PR head was pushed too recently")
+	errStaleComment    = errors.New("comment predates the last action on the PR")
+)
+
+// isRejection returns true if err is a reason handle did not run a comment's commands.
+func isRejection(err error) bool {
+	switch err {
+	case errNoCommand, errNotPullRequest, errLocked, errUnauthorized, errMerged, errHeadRepoDeleted, errIssueMismatch,
+		errSettling, errStaleComment:
+		return true
 	}
-	return issue, resp, nil
+	return false
 }
 
-func isIssueRerunable(issue *github.Issue) bool {
-	// Only handle non-locked pull requests.
-	return issue.IsPullRequest() && !issue.GetLocked()
-}
+// errNoActiveWorkflows is returned when a repo has no active workflows other than the one running this action.
+var errNoActiveWorkflows = errors.New("no active workflows found")
 
-func hasOkToTestLabel(issue *github.Issue) bool {
-	// Gate reruns on "ok-to-test" label presence.
-	for _, label := range issue.Labels {
-		if label.GetName() == canTestLabel {
+// hasActiveWorkflow returns true if any of workflows other than the one running this action is active,
+// or will be once re-enabled because h.enableDisabledWorkflows is set and the commenter is privileged.
+func (h *handler) hasActiveWorkflow(workflows []*github.Workflow, commenterPrivileged bool) bool {
+	canEnable := h.enableDisabledWorkflows && commenterPrivileged
+	for _, workflow := range workflows {
+		if h.isSelfWorkflow(workflow) {
+			continue
+		}
+		if workflow.GetState() == activeState || (canEnable && workflow.GetState() == disabledManuallyState) {
 			return true
 		}
 	}
 	return false
 }
 
-// From API docs:
-// AuthorAssociation is the comment author's relationship to the issue's repository.
-// Possible values are "COLLABORATOR", "CONTRIBUTOR", "FIRST_TIMER", "FIRST_TIME_CONTRIBUTOR", "MEMBER", "OWNER", or "NONE".
-var privilegedAssociations = map[string]struct{}{
-	"collaborator": {},
-	"contributor":  {},
-	"member":       {},
-	"owner":        {},
+// isSelfWorkflow returns true if workflow is the one running this action: the workflow at h.selfWorkflowPath if set,
+// or the one named by GITHUB_WORKFLOW, which is the caller's name when this action runs in a reusable workflow.
+func (h *handler) isSelfWorkflow(workflow *github.Workflow) bool {
+	if h.selfWorkflowPath != "" && h.selfWorkflowPath == workflow.GetPath() {
+		return true
+	}
+	wfName := os.Getenv("GITHUB_WORKFLOW")
+	return wfName == workflow.GetName() || wfName == workflow.GetPath()
+}
+
+// reportNoActiveWorkflows makes a command finding no active workflows visible, since
+// otherwise it looks like the command was ignored.
+func (h *handler) reportNoActiveWorkflows(ctx context.Context, repoOwner, repoName string, prNum int) {
+	if h.ignoreNoWorkflows {
+		h.Debugf("No active workflows found")
+		return
+	}
+	h.Warningf("No active workflows found, nothing to rerun")
+	if h.postSummary {
+		body := "No active workflows were found, so nothing was rerun. Workflows may be disabled.\n"
+		if err := h.createComment(ctx, repoOwner, repoName, prNum, body); err != nil {
+			h.Errorf("Failed to post summary: %v", err)
+		}
+	}
+}
+
+// reportHeadRepoDeleted explains why a command on a PR whose head repo was deleted did nothing.
+func (h *handler) reportHeadRepoDeleted(ctx context.Context, repoOwner, repoName string, prNum int) {
+	h.Warningf("PR %d head repo was deleted, so its workflow runs cannot be found or rerun", prNum)
+	if h.postSummary {
+		body := "This PR's head repository was deleted, so its workflow runs cannot be found or rerun. " +
+			"Push the branch to a new fork and open a new PR to run workflows again.\n"
+		if err := h.createComment(ctx, repoOwner, repoName, prNum, body); err != nil {
+			h.Errorf("Failed to post summary: %v", err)
+		}
+	}
+}
+
+// reportAwaitingApproval replies to login listing the workflows whose runs in results await approval,
+// since login cannot approve them.
+func (h *handler) reportAwaitingApproval(ctx context.Context, repoOwner, repoName string, prNum int, login string, results []rerunResult) {
+	var workflowNames []string
+	for _, result := range results {
+		if result.outcome == outcomeAwaitingApproval {
+			workflowNames = append(workflowNames, result.workflowName)
+		}
+	}
+	if len(workflowNames) == 0 {
+		return
+	}
+	h.Warningf("Workflows %s are awaiting approval, commenter cannot approve", strings.Join(workflowNames, ", "))
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "@%s, runs of these workflows must be approved by a maintainer before they can run:\n\n", login)
+	for _, name := range workflowNames {
+		fmt.Fprintf(sb, "- %s\n", name)
+	}
+	sb.WriteString("\nA repo owner, member, collaborator, or contributor can approve them from the Actions tab, " +
+		"or by commenting the same command.\n")
+	if err := h.createComment(ctx, repoOwner, repoName, prNum, sb.String()); err != nil {
+		h.Errorf("Failed to post approval report: %v", err)
+	}
+}
+
+// rerun reruns the run with runID, or only its failed jobs if opts.failedJobsOnly is set.
+func (h *handler) rerun(ctx context.Context, repoOwner, repoName string, runID int64, opts rerunOptions) (*github.Response, error) {
+	if !opts.failedJobsOnly {
+		return h.Actions.RerunWorkflowByID(ctx, repoOwner, repoName, runID)
+	}
+	// The client does not support this endpoint yet.
+	u := fmt.Sprintf("repos/%v/%v/actions/runs/%v/rerun-failed-jobs", repoOwner, repoName, runID)
+	req, err := h.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %v", err)
+	}
+	return h.Do(ctx, req, nil)
+}
+
+// errNotRerunnableYet is returned when a run cannot be rerun yet and is skipped rather than failed.
+var errNotRerunnableYet = errors.New("run cannot be rerun yet")
+
+// rerunWhenRerunnable reruns the run with runID, handling a run that cannot be rerun yet according to h.notRerunnable.
+func (h *handler) rerunWhenRerunnable(ctx context.Context, repoOwner, repoName string, runID int64, opts rerunOptions) error {
+	for attempt := 1; ; attempt++ {
+		resp, err := h.rerun(ctx, repoOwner, repoName, runID, opts)
+		if err == nil || !isNotRerunnableYet(resp, err) {
+			return err
+		}
+		switch h.notRerunnable {
+		case notRerunnableSkip:
+			return errNotRerunnableYet
+		case notRerunnableRetry:
+			if attempt == notRerunnableAttempts {
+				return errNotRerunnableYet
+			}
+		default:
+			return fmt.Errorf("run cannot be rerun yet: %v", err)
+		}
+		h.Debugf("Workflow run %d cannot be rerun yet (attempt %d/%d), retrying: %v", runID, attempt, notRerunnableAttempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * notRerunnableBackoff):
+		}
+	}
+}
+
+// dispatchOnHead runs run's workflow on pr's head branch by dispatching it, for runs that rerunning would not
+// run on the head, ex. a skipped run, which would be skipped again. The outcome of dispatching is returned.
+// Dispatched runs use the workflow's secrets, so only privileged commenters may dispatch, and only on branches
+// of the base repo.
+func (h *handler) dispatchOnHead(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest,
+	run *github.WorkflowRun, commenterPrivileged bool) string {
+	if !commenterPrivileged {
+		h.Debugf("Commenter cannot dispatch workflow %d for run %d", run.GetWorkflowID(), run.GetID())
+		return outcomeSkippedNoDispatch
+	}
+	if pr.GetHead().GetRepo().GetFullName() != pr.GetBase().GetRepo().GetFullName() {
+		h.Warningf("PR %d head branch is on a fork, so workflow %d cannot be dispatched for run %d",
+			pr.GetNumber(), run.GetWorkflowID(), run.GetID())
+		return outcomeSkippedFork
+	}
+	event := github.CreateWorkflowDispatchEventRequest{Ref: pr.GetHead().GetRef()}
+	resp, err := h.Actions.CreateWorkflowDispatchEventByID(ctx, repoOwner, repoName, run.GetWorkflowID(), event)
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusUnprocessableEntity:
+		h.Warningf("Workflow %d has no workflow_dispatch trigger, so run %d cannot be run again on PR %d head: %v",
+			run.GetWorkflowID(), run.GetID(), pr.GetNumber(), err)
+		return outcomeSkippedNoDispatchTrigger
+	case err != nil:
+		h.Errorf("Failed to dispatch workflow: %v", err)
+		return outcomeDispatchFailed
+	}
+	h.Debugf("Dispatched workflow %d on %s for run %d", run.GetWorkflowID(), pr.GetHead().GetRef(), run.GetID())
+	return outcomeDispatched
+}
+
+// isPermissionDenied returns true if a request failed because the token lacks permission, ex. a rerun
+// by a token without the actions: write permission. Rate limit errors are not permission errors.
+func isPermissionDenied(err error) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusForbidden
+}
+
+// reportPermissionDenied explains how to grant the token the permissions reruns need.
+func (h *handler) reportPermissionDenied(ctx context.Context, repoOwner, repoName string, prNum int) {
+	const body = "Workflows could not be rerun because the token lacks permission to rerun or cancel them. " +
+		"Grant it the `actions: write` permission, ex. with `permissions: {actions: write}` in the workflow running " +
+		"rerun-actions, or use a token with the `repo` scope.\n"
+	h.Errorf("Token lacks the actions: write permission needed to rerun or cancel workflow runs")
+	if h.postSummary {
+		if err := h.createComment(ctx, repoOwner, repoName, prNum, body); err != nil {
+			h.Errorf("Failed to post summary: %v", err)
+		}
+	}
+}
+
+// isNotRerunnableYet returns true if a rerun failed because the run cannot be rerun yet,
+// ex. while it is still being finalized after completing, rather than permanently.
+func isNotRerunnableYet(resp *github.Response, err error) bool {
+	if resp == nil || (resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusConflict) {
+		return false
+	}
+	var errResp *github.ErrorResponse
+	if !errors.As(err, &errResp) {
+		return false
+	}
+	msg := strings.ToLower(errResp.Message)
+	return strings.Contains(msg, "yet") && (strings.Contains(msg, "rerun") || strings.Contains(msg, "re-run"))
+}
+
+// approve approves the run with runID, which is waiting for approval to start.
+func (h *handler) approve(ctx context.Context, repoOwner, repoName string, runID int64) (*github.Response, error) {
+	// The client does not support this endpoint yet.
+	u := fmt.Sprintf("repos/%v/%v/actions/runs/%v/approve", repoOwner, repoName, runID)
+	req, err := h.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %v", err)
+	}
+	return h.Do(ctx, req, nil)
+}
+
+// getComment gets the comment with commentID, retrying transient failures.
+// A nil comment is returned if the comment does not exist.
+func (h *handler) getComment(ctx context.Context, repoOwner, repoName string, commentID int64) (*github.IssueComment, error) {
+	for attempt := 1; ; attempt++ {
+		comment, resp, err := h.Issues.GetComment(ctx, repoOwner, repoName, commentID)
+		if err == nil {
+			return comment, nil
+		}
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		if !isTransientFailure(resp) || attempt == getCommentAttempts {
+			return nil, err
+		}
+		h.Debugf("Failed to get comment (attempt %d/%d), retrying: %v", attempt, getCommentAttempts, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * getCommentBackoff):
+		}
+	}
+}
+
+// isTransientFailure returns true if a failed request may succeed when retried,
+// i.e. no response was received or the server errored.
+func isTransientFailure(resp *github.Response) bool {
+	return resp == nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func (h *handler) getIssueForComment(ctx context.Context, comment *github.IssueComment) (issue *github.Issue, resp *github.Response, err error) {
+	h.Debugf("Issue URL: %s", comment.GetIssueURL())
+	req, err := h.NewRequest(http.MethodGet, comment.GetIssueURL(), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create request: %v", err)
+	}
+	issue = &github.Issue{}
+	if resp, err = h.Do(ctx, req, issue); err != nil {
+		return nil, resp, fmt.Errorf("do request: %v", err)
+	}
+	return issue, resp, nil
+}
+
+// expandWorkflowGroups returns a copy of testsToRerun with each workflow group replaced by the names of
+// allWorkflows that are members of the group. Options of a workflow named explicitly take precedence.
+func (h *handler) expandWorkflowGroups(testsToRerun map[string]rerunOptions, allWorkflows []*github.Workflow) map[string]rerunOptions {
+	expanded := make(map[string]rerunOptions, len(testsToRerun))
+	for name, opts := range testsToRerun {
+		if !strings.HasPrefix(name, testGroupPrefix) {
+			expanded[name] = opts
+		}
+	}
+	// Workflows in several selected groups are rerun as the least restrictive group asks.
+	grouped := make(map[string]struct{})
+	for name, opts := range testsToRerun {
+		if !strings.HasPrefix(name, testGroupPrefix) {
+			continue
+		}
+		group := strings.TrimPrefix(name, testGroupPrefix)
+		members, hasGroup := h.workflowGroups[group]
+		if !hasGroup {
+			h.Warningf("No workflow group named %q configured", group)
+			continue
+		}
+		for _, workflow := range allWorkflows {
+			if !isGroupMember(members, workflow.GetName()) {
+				continue
+			}
+			memberOpts := opts
+			if prevOpts, hasWorkflow := expanded[workflow.GetName()]; hasWorkflow {
+				if _, isGrouped := grouped[workflow.GetName()]; !isGrouped {
+					continue
+				}
+				memberOpts = mergeOptions(prevOpts, opts)
+			}
+			h.Debugf("Workflow %s is a member of group %s", workflow.GetName(), group)
+			expanded[workflow.GetName()] = memberOpts
+			grouped[workflow.GetName()] = struct{}{}
+		}
+	}
+	return expanded
+}
+
+// expandChecks returns a copy of testsToRerun with each check name replaced by the name of the workflow whose
+// run reported that check on pr's head. Checks not reported by Actions are warned about and dropped.
+func (h *handler) expandChecks(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest,
+	testsToRerun map[string]rerunOptions, allWorkflows []*github.Workflow) (map[string]rerunOptions, error) {
+	workflowNames := make(map[int64]string, len(allWorkflows))
+	for _, workflow := range allWorkflows {
+		workflowNames[workflow.GetID()] = workflow.GetName()
+	}
+	expanded := make(map[string]rerunOptions, len(testsToRerun))
+	for name, opts := range testsToRerun {
+		if !strings.HasPrefix(name, testCheckPrefix) {
+			expanded[name] = opts
+		}
+	}
+	for name, opts := range testsToRerun {
+		if !strings.HasPrefix(name, testCheckPrefix) {
+			continue
+		}
+		checkName := strings.TrimPrefix(name, testCheckPrefix)
+		checkRuns, _, err := h.Checks.ListCheckRunsForRef(ctx, repoOwner, repoName, pr.GetHead().GetSHA(),
+			&github.ListCheckRunsOptions{CheckName: &checkName})
+		if err != nil {
+			return nil, fmt.Errorf("list check runs: %v", err)
+		}
+		if len(checkRuns.CheckRuns) == 0 {
+			h.Warningf("No check named %q found on PR %d", checkName, pr.GetNumber())
+			continue
+		}
+		for _, checkRun := range checkRuns.CheckRuns {
+			if checkRun.GetApp().GetSlug() != actionsAppSlug {
+				h.Warningf("Check %q is not reported by GitHub Actions, cannot rerun it", checkName)
+				continue
+			}
+			// An Actions job is the check run it reports.
+			job, _, err := h.Actions.GetWorkflowJobByID(ctx, repoOwner, repoName, checkRun.GetID())
+			if err != nil {
+				return nil, fmt.Errorf("get workflow job: %v", err)
+			}
+			run, _, err := h.Actions.GetWorkflowRunByID(ctx, repoOwner, repoName, job.GetRunID())
+			if err != nil {
+				return nil, fmt.Errorf("get workflow run: %v", err)
+			}
+			workflowName, hasWorkflow := workflowNames[run.GetWorkflowID()]
+			if !hasWorkflow {
+				continue
+			}
+			h.Debugf("Check %s was reported by workflow %s", checkName, workflowName)
+			if _, hasWorkflow := expanded[workflowName]; !hasWorkflow {
+				expanded[workflowName] = opts
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// isWorkflowPathAllowed returns true if path has a prefix in h.workflowPaths, or h.workflowPaths is empty.
+func (h *handler) isWorkflowPathAllowed(path string) bool {
+	if len(h.workflowPaths) == 0 {
+		return true
+	}
+	for _, prefix := range h.workflowPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isGroupMember returns true if workflowName is one of members, or has the prefix of a member ending in "*".
+func isGroupMember(members []string, workflowName string) bool {
+	for _, member := range members {
+		if prefix := strings.TrimSuffix(member, "*"); prefix != member {
+			if strings.HasPrefix(workflowName, prefix) {
+				return true
+			}
+		} else if member == workflowName {
+			return true
+		}
+	}
+	return false
+}
+
+// warnUnmatchedWorkflows emits a warning annotation for each requested workflow name
+// that does not match any workflow in the repo.
+func (h *handler) warnUnmatchedWorkflows(testsToRerun map[string]rerunOptions, allWorkflows []*github.Workflow) {
+	names := make([]string, 0, len(allWorkflows))
+	existing := make(map[string]struct{}, len(allWorkflows))
+	for _, workflow := range allWorkflows {
+		names = append(names, workflow.GetName())
+		existing[workflow.GetName()] = struct{}{}
+	}
+	sort.Strings(names)
+
+	for name := range testsToRerun {
+		if _, exists := existing[name]; exists {
+			continue
+		}
+		if h.verbose {
+			h.Warningf("No workflow named %q found (available workflows: %s)", name, strings.Join(names, ", "))
+		} else {
+			h.Warningf("No workflow named %q found", name)
+		}
+	}
+}
+
+// getPRStack returns pr followed by the open PRs it is stacked on, found by following each PR's base branch
+// to the PR whose head is that branch until the default branch is reached, up to maxStackDepth PRs.
+func (h *handler) getPRStack(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest) ([]*github.PullRequest, error) {
+	stack := []*github.PullRequest{pr}
+	for len(stack) < maxStackDepth {
+		base := stack[len(stack)-1].GetBase()
+		if base.GetRef() == base.GetRepo().GetDefaultBranch() {
+			break
+		}
+		opts := &github.PullRequestListOptions{State: "open", Head: repoOwner + ":" + base.GetRef()}
+		basePRs, _, err := h.PullRequests.List(ctx, repoOwner, repoName, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(basePRs) == 0 {
+			break
+		}
+		h.Debugf("PR %d is stacked on PR %d", stack[len(stack)-1].GetNumber(), basePRs[0].GetNumber())
+		stack = append(stack, basePRs[0])
+	}
+	return stack, nil
+}
+
+// findPRRun pages through a workflow's runs, newest first, for the run matching pr's head SHA,
+// or merge ref SHA if h.matchMergeRef is set. A nil run is returned if no run matches.
+func (h *handler) findPRRun(ctx context.Context, repoOwner, repoName string, workflowID int64, pr *github.PullRequest, since time.Time, opts *github.ListWorkflowRunsOptions) (*github.WorkflowRun, error) {
+	shas := h.prRunSHAs(pr)
+	for {
+		workflowRuns, resp, err := h.Actions.ListWorkflowRunsByID(ctx, repoOwner, repoName, workflowID, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range workflowRuns.WorkflowRuns {
+			// Stop searching runs once an older run is found.
+			if run.GetCreatedAt().Before(since) {
+				h.Debugf("Workflow run older than %s found for PR %d", since.Format(time.RFC3339), pr.GetNumber())
+				return nil, nil
+			}
+			if !h.isRunEventMatched(run.GetEvent()) {
+				h.Debugf("Workflow run %d was triggered by unmatched event %s", run.GetID(), run.GetEvent())
+				continue
+			}
+			// Runs are listed by workflow, but guard against attributing another workflow's run
+			// for the same SHA to this one, ex. when workflow names collide.
+			if run.GetWorkflowID() != workflowID {
+				h.Debugf("Workflow run %d belongs to workflow %d, not %d", run.GetID(), run.GetWorkflowID(), workflowID)
+				continue
+			}
+			// A matching run's SHA will match the PR's head SHA, or its merge ref SHA. Runs are listed newest first,
+			// each as of its latest attempt, so only the latest attempt of the newest matching run is returned.
+			if _, matches := shas[run.GetHeadSHA()]; matches {
+				h.Debugf("Found run matching PR %d SHA %s", pr.GetNumber(), run.GetHeadSHA())
+				return run, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// runScanStart returns the time before which runs are not considered for the PR: when the PR was opened or,
+// if h.sinceLastPush is set, when its head commit was committed, since the commit cannot have been pushed earlier.
+func (h *handler) runScanStart(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest) (time.Time, error) {
+	since := pr.GetCreatedAt()
+	if !h.sinceLastPush {
+		return since, nil
+	}
+	committed, err := h.headCommitTime(ctx, repoOwner, repoName, pr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if committed.After(since) {
+		since = committed
+	}
+	return since, nil
+}
+
+// headCommitTime returns when pr's head commit was committed, which bounds when it was pushed from below.
+func (h *handler) headCommitTime(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest) (time.Time, error) {
+	commit, _, err := h.Git.GetCommit(ctx, repoOwner, repoName, pr.GetHead().GetSHA())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get head commit: %v", err)
+	}
+	return commit.GetCommitter().GetDate(), nil
+}
+
+// sortRuns sorts runs of workflows in h.rerunOrder: by their workflows' names, paths, or IDs, or by creation time.
+// Ties are broken by run ID, so the order is the same for every command.
+func (h *handler) sortRuns(runs []*github.WorkflowRun, workflows []*github.Workflow) {
+	byID := make(map[int64]*github.Workflow, len(workflows))
+	for _, workflow := range workflows {
+		byID[workflow.GetID()] = workflow
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		wi, wj := byID[runs[i].GetWorkflowID()], byID[runs[j].GetWorkflowID()]
+		switch h.rerunOrder {
+		case rerunOrderName:
+			if wi.GetName() != wj.GetName() {
+				return wi.GetName() < wj.GetName()
+			}
+		case rerunOrderPath:
+			if wi.GetPath() != wj.GetPath() {
+				return wi.GetPath() < wj.GetPath()
+			}
+		case rerunOrderID:
+			if wi.GetID() != wj.GetID() {
+				return wi.GetID() < wj.GetID()
+			}
+		case rerunOrderCreated:
+			if ci, cj := runs[i].GetCreatedAt(), runs[j].GetCreatedAt(); !ci.Equal(cj) {
+				return ci.Before(cj.Time)
+			}
+		}
+		return runs[i].GetID() < runs[j].GetID()
+	})
+}
+
+// runEventQuery returns the event to query runs by: the only event in h.runEvents, or all events if it has several.
+func (h *handler) runEventQuery() string {
+	if len(h.runEvents) == 1 {
+		for event := range h.runEvents {
+			return event
+		}
+	}
+	return ""
+}
+
+// isRunEventMatched returns true if runs triggered by event are considered for reruns.
+// Excluded events are never considered, even if also listed in h.runEvents.
+func (h *handler) isRunEventMatched(event string) bool {
+	if _, excluded := h.excludeEvents[event]; excluded {
+		return false
+	}
+	_, matched := h.runEvents[event]
+	return matched
+}
+
+// prRunSHAs returns the SHAs a run for pr may have been triggered for: pr's head SHA and,
+// if h.matchMergeRef is set, the SHA of pr's merge ref. The merge ref is skipped while GitHub
+// is computing it or if pr cannot be merged, since its SHA may then be stale.
+func (h *handler) prRunSHAs(pr *github.PullRequest) map[string]struct{} {
+	shas := map[string]struct{}{pr.GetHead().GetSHA(): {}}
+	if !h.matchMergeRef {
+		return shas
+	}
+	switch mergeSHA := pr.GetMergeCommitSHA(); {
+	case mergeSHA == "":
+		h.Debugf("PR %d has no merge ref", pr.GetNumber())
+	case pr.Mergeable == nil || !pr.GetMergeable():
+		h.Debugf("PR %d merge ref %s may be stale, not matching it", pr.GetNumber(), mergeSHA)
+	default:
+		shas[mergeSHA] = struct{}{}
+	}
+	return shas
+}
+
+// getRequiredChecks returns the set of status check contexts required by branch's protection rules.
+// An unprotected branch has no required checks.
+func (h *handler) getRequiredChecks(ctx context.Context, repoOwner, repoName, branch string) (map[string]struct{}, error) {
+	requiredChecks := make(map[string]struct{})
+	checks, resp, err := h.Repositories.GetRequiredStatusChecks(ctx, repoOwner, repoName, branch)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			h.Debugf("Branch %s has no required status checks", branch)
+			return requiredChecks, nil
+		}
+		return nil, err
+	}
+	for _, checkContext := range checks.Contexts {
+		requiredChecks[checkContext] = struct{}{}
+	}
+	return requiredChecks, nil
+}
+
+// areRequiredChecksGreen returns true if pr's base branch requires status checks and all of them
+// passed on pr's head, whether reported as commit statuses or check runs.
+func (h *handler) areRequiredChecksGreen(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest) (bool, error) {
+	requiredChecks, err := h.getRequiredChecks(ctx, repoOwner, repoName, pr.GetBase().GetRef())
+	if err != nil {
+		return false, fmt.Errorf("get required status checks: %v", err)
+	}
+	if len(requiredChecks) == 0 {
+		return false, nil
+	}
+	passed := make(map[string]struct{})
+	status, _, err := h.Repositories.GetCombinedStatus(ctx, repoOwner, repoName, pr.GetHead().GetSHA(), nil)
+	if err != nil {
+		return false, fmt.Errorf("get combined status: %v", err)
+	}
+	for _, repoStatus := range status.Statuses {
+		if repoStatus.GetState() == successfulConclusion {
+			passed[repoStatus.GetContext()] = struct{}{}
+		}
+	}
+	opts := &github.ListCheckRunsOptions{ListOptions: github.ListOptions{PerPage: maxRunsPerPage}}
+	for {
+		checkRuns, resp, err := h.Checks.ListCheckRunsForRef(ctx, repoOwner, repoName, pr.GetHead().GetSHA(), opts)
+		if err != nil {
+			return false, fmt.Errorf("list check runs: %v", err)
+		}
+		for _, checkRun := range checkRuns.CheckRuns {
+			switch checkRun.GetConclusion() {
+			case successfulConclusion, "neutral", "skipped":
+				passed[checkRun.GetName()] = struct{}{}
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	for checkContext := range requiredChecks {
+		if _, hasPassed := passed[checkContext]; !hasPassed {
+			h.Debugf("Required check %s has not passed", checkContext)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// reportSettling explains that a command did nothing because the PR's head was pushed before min_settle elapsed,
+// and when commands will be accepted.
+func (h *handler) reportSettling(ctx context.Context, repoOwner, repoName string, prNum int, settled time.Time) {
+	body := fmt.Sprintf("Checks on this PR's latest push are still starting, so nothing was rerun. "+
+		"Comment again after %s.\n", settled.UTC().Format(time.RFC3339))
+	h.Warningf("PR %d head was pushed less than %s ago, will not rerun", prNum, h.minSettle)
+	if h.postSummary {
+		if err := h.createComment(ctx, repoOwner, repoName, prNum, body); err != nil {
+			h.Errorf("Failed to post summary: %v", err)
+		}
+	}
+}
+
+// reportRequiredChecksGreen explains that a command did nothing because all required checks passed.
+func (h *handler) reportRequiredChecksGreen(ctx context.Context, repoOwner, repoName string, prNum int) {
+	const body = "All required checks are green, so nothing was rerun.\n"
+	h.Debugf("%s", body)
+	if h.postSummary {
+		if err := h.createComment(ctx, repoOwner, repoName, prNum, body); err != nil {
+			h.Errorf("Failed to post summary: %v", err)
+		}
+	}
+}
+
+// isRunRequired returns true if any of run's jobs report a required status check.
+// Actions jobs report checks named after the job.
+func (h *handler) isRunRequired(ctx context.Context, repoOwner, repoName string, run *github.WorkflowRun, requiredChecks map[string]struct{}) (bool, error) {
+	opts := &github.ListWorkflowJobsOptions{}
+	for {
+		jobs, resp, err := h.Actions.ListWorkflowJobs(ctx, repoOwner, repoName, run.GetID(), opts)
+		if err != nil {
+			return false, err
+		}
+		for _, job := range jobs.Jobs {
+			if _, isRequired := requiredChecks[job.GetName()]; isRequired {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// isRecentSuccess returns true if run succeeded within h.rerunSuccessWithin, so it may be rerun to confirm it is not flaky.
+func (h *handler) isRecentSuccess(run *github.WorkflowRun) bool {
+	if h.rerunSuccessWithin == 0 || time.Since(run.GetUpdatedAt().Time) > h.rerunSuccessWithin {
+		return false
+	}
+	h.Debugf("Workflow run %d succeeded within %s, will rerun", run.GetID(), h.rerunSuccessWithin)
+	return true
+}
+
+// isAwaitingApproval returns true if run is waiting for a maintainer to approve it before starting.
+func isAwaitingApproval(run *github.WorkflowRun) bool {
+	return run.GetStatus() == actionRequired || run.GetConclusion() == actionRequired
+}
+
+// checkIssueRerunable returns errNotPullRequest or errLocked if workflows cannot be rerun for issue.
+func checkIssueRerunable(issue *github.Issue) error {
+	// Only handle non-locked pull requests.
+	if !issue.IsPullRequest() {
+		return errNotPullRequest
+	}
+	if issue.GetLocked() {
+		return errLocked
+	}
+	return nil
+}
+
+// maxCommentSample bounds the length of a comment body sample logged for comments without commands.
+const maxCommentSample = 80
+
+// commentSample returns the start of body's first line, to log what kinds of comments have no commands.
+func commentSample(body string) string {
+	if i := strings.IndexByte(body, '\n'); i >= 0 {
+		body = body[:i]
+	}
+	if len(body) > maxCommentSample {
+		body = body[:maxCommentSample] + "..."
+	}
+	return body
+}
+
+// issueURLNumber returns the issue number at the end of issueURL, ex. 1 for ".../repos/o/r/issues/1".
+func issueURLNumber(issueURL string) (int, bool) {
+	const issuesPath = "/issues/"
+	i := strings.LastIndex(issueURL, issuesPath)
+	if i < 0 {
+		return 0, false
+	}
+	num, err := strconv.Atoi(issueURL[i+len(issuesPath):])
+	return num, err == nil
+}
+
+// removeLabel removes the label named name from issue. Removing a label issue does not have is a no-op.
+func (h *handler) removeLabel(ctx context.Context, repoOwner, repoName string, issue *github.Issue, name string) error {
+	if !hasLabel(issue.Labels, name) {
+		h.Debugf("Issue %d has no %q label", issue.GetNumber(), name)
+		return nil
+	}
+	resp, err := h.Issues.RemoveLabelForIssue(ctx, repoOwner, repoName, issue.GetNumber(), name)
+	// The label may have been removed since issue was read.
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func hasOkToTestLabel(issue *github.Issue) bool {
+	// Gate reruns on "ok-to-test" label presence.
+	return hasLabel(issue.Labels, canTestLabel)
+}
+
+// From API docs:
+// AuthorAssociation is the comment author's relationship to the issue's repository.
+// Possible values are "COLLABORATOR", "CONTRIBUTOR", "FIRST_TIMER", "FIRST_TIME_CONTRIBUTOR", "MEMBER", "OWNER", or "NONE".
+var privilegedAssociations = map[string]struct{}{
+	"collaborator": {},
+	"contributor":  {},
+	"member":       {},
+	"owner":        {},
 }
 
 // isCommenterPrivileged returns true if authorAssoc is a privileged keyword:
@@ -222,29 +1748,123 @@ func isCommenterPrivileged(authorAssoc string) bool {
 	return isPrivileged
 }
 
-func parseCommentsToWorkflowNames(commentBody string) map[string]struct{} {
-	testsToRerun := make(map[string]struct{})
-	scanner := bufio.NewScanner(strings.NewReader(commentBody))
-	for scanner.Scan() {
-		var splitComment []string
-		for _, word := range strings.Split(scanner.Text(), " ") {
-			if word = strings.TrimSpace(word); word != "" {
-				splitComment = append(splitComment, word)
-			}
-		}
-		// Ignore non-command comments or comments smaller than any command size.
-		if len(splitComment) == 0 || len(splitComment[0]) < 5 || splitComment[0][0] != '/' {
-			return nil
+// isFirstTimer returns true if authorAssoc is that of a user who has not contributed to the repo before.
+func isFirstTimer(authorAssoc string) bool {
+	switch strings.ToLower(authorAssoc) {
+	case "first_timer", "first_time_contributor":
+		return true
+	}
+	return false
+}
+
+// hasPrivilegedApproval returns true if a privileged reviewer's latest review of the PR numbered prNum approves it.
+func (h *handler) hasPrivilegedApproval(ctx context.Context, repoOwner, repoName string, prNum int) (bool, error) {
+	// Reviews are listed oldest first, so later reviews by a reviewer replace earlier ones.
+	states := make(map[string]string)
+	opts := &github.ListOptions{}
+	for {
+		reviews, resp, err := h.PullRequests.ListReviews(ctx, repoOwner, repoName, prNum, opts)
+		if err != nil {
+			return false, err
 		}
-		switch splitComment[0][1:] {
-		case retestAllWorkflowsCommand:
-			testsToRerun[testAll] = struct{}{}
-		case testWorkflowCommand:
-			if len(splitComment) < 2 {
+		for _, review := range reviews {
+			// Comments do not change a reviewer's approval.
+			if review.GetState() == "COMMENTED" || !isCommenterPrivileged(review.GetAuthorAssociation()) {
 				continue
 			}
-			testsToRerun[splitComment[1]] = struct{}{}
+			states[review.GetUser().GetLogin()] = review.GetState()
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	for _, state := range states {
+		if state == "APPROVED" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isOrgMember returns true if login is a member of org. Results are cached for the life of h.
+func (h *handler) isOrgMember(ctx context.Context, org, login string) (bool, error) {
+	if isMember, cached := h.orgMembers[login]; cached {
+		return isMember, nil
+	}
+	isMember, _, err := h.Organizations.IsMember(ctx, org, login)
+	if err != nil {
+		return false, err
+	}
+	if h.orgMembers == nil {
+		h.orgMembers = make(map[string]bool)
+	}
+	h.orgMembers[login] = isMember
+	return isMember, nil
+}
+
+// teamMembershipTTL is how long a maintainers_team membership check is cached, so membership changes apply
+// to commands handled later by the same process.
+const teamMembershipTTL = 5 * time.Minute
+
+// teamMembership is a cached maintainers_team membership check.
+type teamMembership struct {
+	isMember bool
+	checked  time.Time
+}
+
+// isTeamMember returns true if login is an active member of h.maintainersTeam.
+func (h *handler) isTeamMember(ctx context.Context, login string) (bool, error) {
+	if cached, isCached := h.teamMembers[login]; isCached && time.Since(cached.checked) < teamMembershipTTL {
+		return cached.isMember, nil
+	}
+	split := strings.SplitN(h.maintainersTeam, "/", 2)
+	membership, resp, err := h.Teams.GetTeamMembershipBySlug(ctx, split[0], split[1], login)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return false, err
+	}
+	// Non-members are not found. Invited members are pending until they accept.
+	isMember := err == nil && membership.GetState() == "active"
+	if h.teamMembers == nil {
+		h.teamMembers = make(map[string]teamMembership)
+	}
+	h.teamMembers[login] = teamMembership{isMember: isMember, checked: time.Now()}
+	return isMember, nil
+}
+
+// associationRanks orders author associations from least to most trusted.
+var associationRanks = map[string]int{
+	"none":                   0,
+	"mannequin":              0,
+	"first_timer":            1,
+	"first_time_contributor": 1,
+	"contributor":            2,
+	"collaborator":           3,
+	"member":                 4,
+	"owner":                  5,
+}
+
+// hasMinAssociation returns true if authorAssoc is at least as trusted as minAssoc.
+func hasMinAssociation(authorAssoc, minAssoc string) bool {
+	return associationRanks[strings.ToLower(authorAssoc)] >= associationRanks[strings.ToLower(minAssoc)]
+}
+
+// isCommenterAuthorized returns true if authorAssoc meets the least minimum association configured
+// for issue's labels or, if no labels are configured, the default minimum association.
+// Without either, authorAssoc must be privileged.
+func (h *handler) isCommenterAuthorized(issue *github.Issue, authorAssoc string) bool {
+	minAssoc := ""
+	for _, label := range issue.Labels {
+		labelAssoc, hasLabel := h.labelAssociations[label.GetName()]
+		if hasLabel && (minAssoc == "" || associationRanks[labelAssoc] < associationRanks[minAssoc]) {
+			minAssoc = labelAssoc
 		}
 	}
-	return testsToRerun
+	if minAssoc == "" {
+		minAssoc = h.defaultMinAssociation
+	}
+	if minAssoc == "" {
+		return isCommenterPrivileged(authorAssoc)
+	}
+	return hasMinAssociation(authorAssoc, minAssoc)
 }
@@ -0,0 +1,2132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	actions "github.com/sethvargo/go-githubactions"
+)
+
+const (
+	testOwner   = "o"
+	testRepo    = "r"
+	testPRNum   = 1
+	testHeadSHA = "headsha"
+)
+
+// testPRCreatedAt is when test PRs were opened. Runs of test PRs are created after it.
+var testPRCreatedAt = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeResponse is a canned API response.
+type fakeResponse struct {
+	status int
+	body   interface{}
+	header http.Header
+}
+
+// fakeAPI serves canned GitHub API responses, recording the requests made to it.
+// Requests without a canned response get a 404.
+type fakeAPI struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]fakeResponse
+	// queued are responses sent once each, in order, before those in responses.
+	queued   map[string][]fakeResponse
+	requests []*http.Request
+	// bodies are the bodies of requests.
+	bodies [][]byte
+}
+
+// newFakeAPI starts a fakeAPI, which is closed when t's test completes.
+func newFakeAPI(t *testing.T) *fakeAPI {
+	api := &fakeAPI{responses: make(map[string]fakeResponse), queued: make(map[string][]fakeResponse)}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+// handle responds to requests with method for path, ex. "/repos/o/r/pulls/1", with status and body encoded as JSON.
+// A path may end in a page query, ex. "?page=2", to respond to requests for that page only.
+func (api *fakeAPI) handle(method, path string, status int, body interface{}) {
+	api.handlePage(method, path, status, body, nil)
+}
+
+// handlePage is like handle, but also responds with header, ex. to link to the next page.
+func (api *fakeAPI) handlePage(method, path string, status int, body interface{}, header http.Header) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.responses[method+" "+path] = fakeResponse{status: status, body: body, header: header}
+}
+
+// handleOnce responds to the next request with method for path that has no earlier queued response, after which
+// requests are responded to as set by handle.
+func (api *fakeAPI) handleOnce(method, path string, status int, body interface{}) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	key := method + " " + path
+	api.queued[key] = append(api.queued[key], fakeResponse{status: status, body: body})
+}
+
+func (api *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	body, _ := ioutil.ReadAll(r.Body)
+	api.requests = append(api.requests, r)
+	api.bodies = append(api.bodies, body)
+	key := r.Method + " " + r.URL.Path
+	resp, ok := api.responses[key]
+	if page := r.URL.Query().Get("page"); page != "" {
+		if pageResp, isPage := api.responses[key+"?page="+page]; isPage {
+			resp, ok = pageResp, true
+		}
+	}
+	if queued := api.queued[key]; len(queued) != 0 {
+		resp, ok = queued[0], true
+		api.queued[key] = queued[1:]
+	}
+	api.mu.Unlock()
+	if !ok {
+		resp = fakeResponse{status: http.StatusNotFound, body: map[string]string{"message": "Not Found"}}
+	}
+	for name, values := range resp.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	if resp.body != nil {
+		json.NewEncoder(w).Encode(resp.body)
+	}
+}
+
+// called returns true if a request with method for path was made, ignoring queries.
+func (api *fakeAPI) called(method, path string) bool {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for _, req := range api.requests {
+		if req.Method == method && req.URL.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// calls returns the number of requests made with method for path, ignoring queries.
+func (api *fakeAPI) calls(method, path string) (n int) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for _, req := range api.requests {
+		if req.Method == method && req.URL.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+// body decodes the body of the last request with method for path into v.
+func (api *fakeAPI) body(t *testing.T, method, path string, v interface{}) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for i := len(api.requests) - 1; i >= 0; i-- {
+		if req := api.requests[i]; req.Method == method && req.URL.Path == path {
+			if err := json.Unmarshal(api.bodies[i], v); err != nil {
+				t.Fatalf("decode %s %s body: %v", method, path, err)
+			}
+			return
+		}
+	}
+	t.Fatalf("no %s %s request", method, path)
+}
+
+// requestBodies returns the bodies of requests with method for path, in the order they were made.
+func (api *fakeAPI) requestBodies(method, path string) (bodies []string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for i, req := range api.requests {
+		if req.Method == method && req.URL.Path == path {
+			bodies = append(bodies, string(api.bodies[i]))
+		}
+	}
+	return bodies
+}
+
+// url returns the URL of path on api.
+func (api *fakeAPI) url(path string) string {
+	return api.server.URL + path
+}
+
+// newTestHandler returns a handler with default inputs whose client sends requests to api.
+func newTestHandler(t *testing.T, api *fakeAPI) *handler {
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(api.server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BaseURL = baseURL
+	h := &handler{Client: client, Action: actions.New()}
+	if errs := h.loadInputs(); len(errs) != 0 {
+		t.Fatalf("load inputs: %v", errs)
+	}
+	return h
+}
+
+// testPR returns an open PR whose head branch is in the repo.
+func testPR() *github.PullRequest {
+	repo := &github.Repository{FullName: github.String(testOwner + "/" + testRepo), DefaultBranch: github.String("main")}
+	return &github.PullRequest{
+		Number:    github.Int(testPRNum),
+		State:     github.String("open"),
+		CreatedAt: &testPRCreatedAt,
+		User:      &github.User{Login: github.String("author")},
+		Head:      &github.PullRequestBranch{Ref: github.String("feature"), SHA: github.String(testHeadSHA), Repo: repo},
+		Base:      &github.PullRequestBranch{Ref: github.String("main"), SHA: github.String("basesha"), Repo: repo},
+	}
+}
+
+// testWorkflow returns an active workflow with id and name.
+func testWorkflow(id int64, name string) *github.Workflow {
+	return &github.Workflow{
+		ID:    github.Int64(id),
+		Name:  github.String(name),
+		Path:  github.String(fmt.Sprintf(".github/workflows/%s.yaml", name)),
+		State: github.String(activeState),
+	}
+}
+
+// testRun returns a completed pull_request run of the workflow with workflowID.
+func testRun(id, workflowID int64, sha, conclusion string) *github.WorkflowRun {
+	return &github.WorkflowRun{
+		ID:         github.Int64(id),
+		WorkflowID: github.Int64(workflowID),
+		HeadSHA:    github.String(sha),
+		Event:      github.String(defaultRunEvent),
+		Status:     github.String(completedStatus),
+		Conclusion: github.String(conclusion),
+		CreatedAt:  &github.Timestamp{Time: testPRCreatedAt.Add(time.Hour)},
+	}
+}
+
+// handleWorkflows makes api list workflows and, for each, runs.
+func (api *fakeAPI) handleWorkflows(workflows []*github.Workflow, runs map[int64][]*github.WorkflowRun) {
+	api.handle(http.MethodGet, "/repos/o/r/actions/workflows", http.StatusOK,
+		&github.Workflows{TotalCount: github.Int(len(workflows)), Workflows: workflows})
+	for _, workflow := range workflows {
+		workflowRuns := runs[workflow.GetID()]
+		api.handle(http.MethodGet, fmt.Sprintf("/repos/o/r/actions/workflows/%d/runs", workflow.GetID()), http.StatusOK,
+			&github.WorkflowRuns{TotalCount: github.Int(len(workflowRuns)), WorkflowRuns: workflowRuns})
+	}
+}
+
+func TestFindPRRunPaginates(t *testing.T) {
+	api := newFakeAPI(t)
+	h := newTestHandler(t, api)
+	h.runsPerPage = 1
+	path := "/repos/o/r/actions/workflows/1/runs"
+	next := http.Header{"Link": {fmt.Sprintf(`<%s?page=2>; rel="next", <%s?page=2>; rel="last"`, api.url(path), api.url(path))}}
+	api.handlePage(http.MethodGet, path, http.StatusOK,
+		&github.WorkflowRuns{WorkflowRuns: []*github.WorkflowRun{testRun(11, 1, "othersha", failureConclusion)}}, next)
+	api.handle(http.MethodGet, path+"?page=2", http.StatusOK,
+		&github.WorkflowRuns{WorkflowRuns: []*github.WorkflowRun{testRun(10, 1, testHeadSHA, failureConclusion)}})
+
+	opts := &github.ListWorkflowRunsOptions{ListOptions: github.ListOptions{PerPage: h.runsPerPage}}
+	run, err := h.findPRRun(context.Background(), testOwner, testRepo, 1, testPR(), testPRCreatedAt, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.GetID() != 10 {
+		t.Errorf("got run %d, want 10", run.GetID())
+	}
+	if len(api.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(api.requests))
+	}
+	for i, req := range api.requests {
+		if perPage := req.URL.Query().Get("per_page"); perPage != "1" {
+			t.Errorf("request %d: got per_page %q, want 1", i, perPage)
+		}
+	}
+}
+
+func TestFindPRRunStopsAtOlderRuns(t *testing.T) {
+	api := newFakeAPI(t)
+	h := newTestHandler(t, api)
+	old := testRun(10, 1, testHeadSHA, failureConclusion)
+	old.CreatedAt = &github.Timestamp{Time: testPRCreatedAt.Add(-time.Hour)}
+	api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")}, map[int64][]*github.WorkflowRun{1: {old}})
+
+	run, err := h.findPRRun(context.Background(), testOwner, testRepo, 1, testPR(), testPRCreatedAt, &github.ListWorkflowRunsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run != nil {
+		t.Errorf("got run %d, want none", run.GetID())
+	}
+}
+
+func TestRerunPRWorkflows(t *testing.T) {
+	build, lint, docs := testWorkflow(1, "build"), testWorkflow(2, "lint"), testWorkflow(3, "docs")
+	docs.State = github.String(disabledManuallyState)
+	runs := map[int64][]*github.WorkflowRun{
+		1: {testRun(10, 1, testHeadSHA, failureConclusion)},
+		2: {testRun(20, 2, testHeadSHA, successfulConclusion)},
+		3: {testRun(30, 3, testHeadSHA, failureConclusion)},
+	}
+	tests := []struct {
+		name         string
+		testsToRerun map[string]rerunOptions
+		want         map[string]string
+		wantReruns   []int64
+	}{
+		{
+			name:         "all",
+			testsToRerun: map[string]rerunOptions{testAll: {}},
+			want:         map[string]string{"build": outcomeRerun, "lint": outcomeSkippedSucceeded},
+			wantReruns:   []int64{10},
+		},
+		{
+			name:         "named workflow",
+			testsToRerun: map[string]rerunOptions{"build": {}},
+			want:         map[string]string{"build": outcomeRerun},
+			wantReruns:   []int64{10},
+		},
+		{
+			name:         "succeeded workflow",
+			testsToRerun: map[string]rerunOptions{"lint": {}},
+			want:         map[string]string{"lint": outcomeSkippedSucceeded},
+		},
+		{
+			name:         "inactive workflow",
+			testsToRerun: map[string]rerunOptions{"docs": {}},
+			want:         map[string]string{},
+		},
+		{
+			name:         "unknown workflow",
+			testsToRerun: map[string]rerunOptions{"e2e": {}},
+			want:         map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows([]*github.Workflow{build, lint, docs}, runs)
+			for _, id := range []int64{10, 20, 30} {
+				api.handle(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id), http.StatusCreated, nil)
+			}
+			h := newTestHandler(t, api)
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), tt.testsToRerun, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string, len(results))
+			for _, result := range results {
+				got[result.workflowName] = result.outcome
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got outcomes %v, want %v", got, tt.want)
+			}
+			for _, id := range []int64{10, 20, 30} {
+				rerun := api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id))
+				if want := containsID(tt.wantReruns, id); rerun != want {
+					t.Errorf("run %d rerun: got %t, want %t", id, rerun, want)
+				}
+			}
+		})
+	}
+}
+
+// handleReruns makes api accept reruns of the runs with ids.
+func (api *fakeAPI) handleReruns(ids ...int64) {
+	for _, id := range ids {
+		api.handle(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id), http.StatusCreated, nil)
+	}
+}
+
+// outcomes returns results' outcomes by workflow name.
+func outcomes(results []rerunResult) map[string]string {
+	got := make(map[string]string, len(results))
+	for _, result := range results {
+		got[result.workflowName] = result.outcome
+	}
+	return got
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// testComment returns a comment on the test PR by a member.
+func testComment(api *fakeAPI, body string) *github.IssueComment {
+	return &github.IssueComment{
+		ID:                github.Int64(100),
+		Body:              github.String(body),
+		User:              &github.User{Login: github.String("maintainer")},
+		AuthorAssociation: github.String("MEMBER"),
+		IssueURL:          github.String(api.url(fmt.Sprintf("/repos/o/r/issues/%d", testPRNum))),
+	}
+}
+
+// testIssue returns the test PR's issue.
+func testIssue() *github.Issue {
+	return &github.Issue{
+		Number:           github.Int(testPRNum),
+		PullRequestLinks: &github.PullRequestLinks{},
+	}
+}
+
+func TestHandleComment(t *testing.T) {
+	merged := testPR()
+	merged.Merged = github.Bool(true)
+	notPR := testIssue()
+	notPR.PullRequestLinks = nil
+	locked := testIssue()
+	locked.Locked = github.Bool(true)
+
+	tests := []struct {
+		name        string
+		body        string
+		association string
+		issue       *github.Issue
+		pr          *github.PullRequest
+		wantErr     error
+		wantRerun   bool
+	}{
+		{name: "no command", body: "looks good", wantErr: errNoCommand},
+		{name: "unknown command", body: "/rerun-everything", wantErr: errNoCommand},
+		{name: "rerun all", body: "/rerun-all", wantRerun: true},
+		{name: "rerun workflow", body: "/rerun-workflow build", wantRerun: true},
+		{name: "not a PR", body: "/rerun-all", issue: notPR, wantErr: errNotPullRequest},
+		{name: "locked", body: "/rerun-all", issue: locked, wantErr: errLocked},
+		{name: "unauthorized", body: "/rerun-all", association: "NONE", wantErr: errUnauthorized},
+		{name: "merged", body: "/rerun-all", pr: merged, wantErr: errMerged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			issue, pr := tt.issue, tt.pr
+			if issue == nil {
+				issue = testIssue()
+			}
+			if pr == nil {
+				pr = testPR()
+			}
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, issue)
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, pr)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/rerun", http.StatusCreated, nil)
+			h := newTestHandler(t, api)
+			comment := testComment(api, tt.body)
+			if tt.association != "" {
+				comment.AuthorAssociation = github.String(tt.association)
+			}
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, comment); err != tt.wantErr {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != tt.wantRerun {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantRerun)
+			}
+		})
+	}
+}
+
+func TestHasActiveWorkflow(t *testing.T) {
+	disabled := testWorkflow(1, "build")
+	disabled.State = github.String(disabledManuallyState)
+	tests := []struct {
+		name               string
+		workflows          []*github.Workflow
+		enable, privileged bool
+		want               bool
+	}{
+		{name: "none"},
+		{name: "active", workflows: []*github.Workflow{testWorkflow(1, "build")}, want: true},
+		{name: "disabled", workflows: []*github.Workflow{disabled}},
+		{name: "disabled, enable by unprivileged", workflows: []*github.Workflow{disabled}, enable: true},
+		{name: "disabled, enable by privileged", workflows: []*github.Workflow{disabled}, enable: true, privileged: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{enableDisabledWorkflows: tt.enable}
+			if got := h.hasActiveWorkflow(tt.workflows, tt.privileged); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsEnablesDisabled(t *testing.T) {
+	disabled := testWorkflow(1, "build")
+	disabled.State = github.String(disabledManuallyState)
+	api := newFakeAPI(t)
+	api.handleWorkflows([]*github.Workflow{disabled},
+		map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+	api.handle(http.MethodPut, "/repos/o/r/actions/workflows/1/enable", http.StatusNoContent, nil)
+	api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/rerun", http.StatusCreated, nil)
+	h := newTestHandler(t, api)
+	h.enableDisabledWorkflows = true
+
+	results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].outcome != outcomeRerun {
+		t.Errorf("got results %+v, want build rerun", results)
+	}
+	if !api.called(http.MethodPut, "/repos/o/r/actions/workflows/1/enable") {
+		t.Errorf("workflow was not enabled")
+	}
+
+	if _, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, false); err != errNoActiveWorkflows {
+		t.Errorf("unprivileged: got error %v, want %v", err, errNoActiveWorkflows)
+	}
+}
+
+func TestSetBotLogin(t *testing.T) {
+	api := newFakeAPI(t)
+	h := newTestHandler(t, api)
+	h.setBotLogin(context.Background())
+	if h.botLogin != defaultBotLogin || h.botLoginDetected {
+		t.Errorf("installation token: got %q detected %t, want %q", h.botLogin, h.botLoginDetected, defaultBotLogin)
+	}
+	api.handle(http.MethodGet, "/user", http.StatusOK, &github.User{Login: github.String("machine-user")})
+	h.setBotLogin(context.Background())
+	if h.botLogin != "machine-user" || !h.botLoginDetected {
+		t.Errorf("personal access token: got %q detected %t, want %q detected", h.botLogin, h.botLoginDetected, "machine-user")
+	}
+
+	if err := os.Setenv("INPUT_BOT_LOGIN", "machine-user"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("INPUT_BOT_LOGIN")
+	h = newTestHandler(t, api)
+	out := &bytes.Buffer{}
+	h.Action = actions.NewWithWriter(out)
+	h.setBotLogin(context.Background())
+	if h.botLogin != "machine-user" || h.botLoginDetected {
+		t.Errorf("bot_login: got %q detected %t, want %q", h.botLogin, h.botLoginDetected, "machine-user")
+	}
+	if !strings.Contains(out.String(), "::warning::bot_login machine-user owns repo_token") {
+		t.Errorf("got output %q, want a warning that machine-user's commands are ignored", out.String())
+	}
+}
+
+func TestHandleTokenOwnerCommand(t *testing.T) {
+	api := newFakeAPI(t)
+	comment := testComment(api, "/rerun-all")
+	api.handle(http.MethodGet, "/repos/o/r/issues/comments/100", http.StatusOK, comment)
+	api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+	api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+	api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+		map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+	api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/rerun", http.StatusCreated, nil)
+	api.handle(http.MethodGet, "/user", http.StatusOK, comment.GetUser())
+	h := newTestHandler(t, api)
+	h.setBotLogin(context.Background())
+
+	if err := h.handle(context.Background(), testOwner, testRepo, comment.GetID()); err != nil {
+		t.Fatal(err)
+	}
+	if !api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun") {
+		t.Errorf("token owner's command was not run")
+	}
+}
+
+func TestIsNotRerunnableYet(t *testing.T) {
+	errorResponse := func(status int, message string) (*github.Response, error) {
+		resp := &github.Response{Response: &http.Response{StatusCode: status}}
+		return resp, &github.ErrorResponse{Response: resp.Response, Message: message}
+	}
+	tests := []struct {
+		name    string
+		status  int
+		message string
+		want    bool
+	}{
+		{name: "forbidden", status: http.StatusForbidden, message: "This workflow run cannot be rerun yet", want: true},
+		{name: "conflict", status: http.StatusConflict, message: "Unable to re-run this workflow run yet", want: true},
+		{name: "permission", status: http.StatusForbidden, message: "Resource not accessible by integration"},
+		{name: "not found", status: http.StatusNotFound, message: "This workflow run cannot be rerun yet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNotRerunnableYet(errorResponse(tt.status, tt.message)); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+	if isNotRerunnableYet(nil, fmt.Errorf("connection reset")) {
+		t.Errorf("got true for an error without a response")
+	}
+}
+
+func TestRerunWhenRerunnable(t *testing.T) {
+	tests := []struct {
+		notRerunnable string
+		wantErr       func(error) bool
+	}{
+		{notRerunnable: notRerunnableSkip, wantErr: func(err error) bool { return err == errNotRerunnableYet }},
+		{notRerunnable: notRerunnableFail, wantErr: func(err error) bool { return err != nil && err != errNotRerunnableYet }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.notRerunnable, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/rerun", http.StatusForbidden,
+				map[string]string{"message": "This workflow run cannot be rerun yet"})
+			h := newTestHandler(t, api)
+			h.notRerunnable = tt.notRerunnable
+
+			if err := h.rerunWhenRerunnable(context.Background(), testOwner, testRepo, 10, rerunOptions{}); !tt.wantErr(err) {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
+
+func TestHandleIgnoresBotComments(t *testing.T) {
+	api := newFakeAPI(t)
+	comment := testComment(api, "/rerun-all")
+	comment.User.Login = github.String(defaultBotLogin)
+	api.handle(http.MethodGet, "/repos/o/r/issues/comments/100", http.StatusOK, comment)
+	h := newTestHandler(t, api)
+
+	if err := h.handle(context.Background(), testOwner, testRepo, comment.GetID()); err != errNoCommand {
+		t.Errorf("got error %v, want %v", err, errNoCommand)
+	}
+	if api.called(http.MethodGet, "/repos/o/r/issues/1") {
+		t.Errorf("bot comment's issue was fetched")
+	}
+}
+
+func TestGetComment(t *testing.T) {
+	defer func(backoff time.Duration) { getCommentBackoff = backoff }(getCommentBackoff)
+	getCommentBackoff = time.Millisecond
+	const path = "/repos/o/r/issues/comments/100"
+	serverError := map[string]string{"message": "Server Error"}
+	tests := []struct {
+		name        string
+		setup       func(api *fakeAPI)
+		wantComment bool
+		wantErr     bool
+		wantCalls   int
+	}{
+		{
+			name: "retried server error",
+			setup: func(api *fakeAPI) {
+				api.handleOnce(http.MethodGet, path, http.StatusInternalServerError, serverError)
+				api.handle(http.MethodGet, path, http.StatusOK, &github.IssueComment{ID: github.Int64(100)})
+			},
+			wantComment: true,
+			wantCalls:   2,
+		},
+		{
+			name:      "not found",
+			setup:     func(api *fakeAPI) {},
+			wantCalls: 1,
+		},
+		{
+			name: "persistent server error",
+			setup: func(api *fakeAPI) {
+				api.handle(http.MethodGet, path, http.StatusBadGateway, serverError)
+			},
+			wantErr:   true,
+			wantCalls: getCommentAttempts,
+		},
+		{
+			name: "forbidden",
+			setup: func(api *fakeAPI) {
+				api.handle(http.MethodGet, path, http.StatusForbidden, map[string]string{"message": "Forbidden"})
+			},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			tt.setup(api)
+			h := newTestHandler(t, api)
+
+			comment, err := h.getComment(context.Background(), testOwner, testRepo, 100)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("got error %v, want error: %t", err, tt.wantErr)
+			}
+			if gotComment := comment != nil; gotComment != tt.wantComment {
+				t.Errorf("got comment %v, want comment: %t", comment, tt.wantComment)
+			}
+			if calls := api.calls(http.MethodGet, path); calls != tt.wantCalls {
+				t.Errorf("got %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIsTeamMember(t *testing.T) {
+	const path = "/orgs/org/teams/maintainers/memberships/alice"
+	tests := []struct {
+		name       string
+		status     int
+		membership *github.Membership
+		want       bool
+		wantErr    bool
+	}{
+		{name: "active", status: http.StatusOK, membership: &github.Membership{State: github.String("active")}, want: true},
+		{name: "pending", status: http.StatusOK, membership: &github.Membership{State: github.String("pending")}},
+		{name: "not a member", status: http.StatusNotFound},
+		{name: "API error", status: http.StatusInternalServerError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, path, tt.status, tt.membership)
+			h := newTestHandler(t, api)
+			h.maintainersTeam = "org/maintainers"
+
+			isMember, err := h.isTeamMember(context.Background(), "alice")
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, tt.wantErr)
+			}
+			if isMember != tt.want {
+				t.Errorf("got member %t, want %t", isMember, tt.want)
+			}
+			if _, cached := h.teamMembers["alice"]; cached == tt.wantErr {
+				t.Errorf("got cached %t, want %t", cached, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsTeamMemberCache(t *testing.T) {
+	const path = "/orgs/org/teams/maintainers/memberships/alice"
+	api := newFakeAPI(t)
+	api.handleOnce(http.MethodGet, path, http.StatusOK, &github.Membership{State: github.String("active")})
+	h := newTestHandler(t, api)
+	h.maintainersTeam = "org/maintainers"
+
+	// alice is removed from the team after the first check, which is cached until it expires.
+	for i, want := range []bool{true, true} {
+		if isMember, err := h.isTeamMember(context.Background(), "alice"); err != nil || isMember != want {
+			t.Fatalf("check %d: got member %t error %v, want %t", i, isMember, err, want)
+		}
+	}
+	if calls := api.calls(http.MethodGet, path); calls != 1 {
+		t.Errorf("got %d membership requests, want 1", calls)
+	}
+	cached := h.teamMembers["alice"]
+	cached.checked = cached.checked.Add(-teamMembershipTTL)
+	h.teamMembers["alice"] = cached
+	if isMember, err := h.isTeamMember(context.Background(), "alice"); err != nil || isMember {
+		t.Errorf("after expiry: got member %t error %v, want false", isMember, err)
+	}
+	if calls := api.calls(http.MethodGet, path); calls != 2 {
+		t.Errorf("got %d membership requests, want 2", calls)
+	}
+}
+
+func TestIsCommenterAuthorized(t *testing.T) {
+	labeled := func(names ...string) *github.Issue {
+		issue := testIssue()
+		for _, name := range names {
+			issue.Labels = append(issue.Labels, &github.Label{Name: github.String(name)})
+		}
+		return issue
+	}
+	labelAssociations := map[string]string{"trusted": "contributor", "external": "first_timer", "core": "member"}
+	tests := []struct {
+		name           string
+		defaultMin     string
+		issue          *github.Issue
+		authorizedFrom string
+	}{
+		// Without configured minimums, privileged associations are authorized.
+		{name: "no labels", issue: labeled(), authorizedFrom: "contributor"},
+		{name: "unconfigured label", issue: labeled("bug"), authorizedFrom: "contributor"},
+		{name: "default", defaultMin: "member", issue: labeled(), authorizedFrom: "member"},
+		{name: "label", issue: labeled("core"), authorizedFrom: "member"},
+		{name: "label over default", defaultMin: "owner", issue: labeled("external"), authorizedFrom: "first_timer"},
+		{name: "least of labels", issue: labeled("core", "trusted"), authorizedFrom: "contributor"},
+	}
+	associations := []string{"NONE", "FIRST_TIMER", "FIRST_TIME_CONTRIBUTOR", "CONTRIBUTOR", "COLLABORATOR", "MEMBER", "OWNER"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{labelAssociations: labelAssociations, defaultMinAssociation: tt.defaultMin}
+			for _, assoc := range associations {
+				want := hasMinAssociation(assoc, tt.authorizedFrom)
+				if got := h.isCommenterAuthorized(tt.issue, assoc); got != want {
+					t.Errorf("%s: got authorized %t, want %t", assoc, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHasMinAssociation(t *testing.T) {
+	tests := []struct {
+		assoc, min string
+		want       bool
+	}{
+		{assoc: "OWNER", min: "member", want: true},
+		{assoc: "MEMBER", min: "member", want: true},
+		{assoc: "COLLABORATOR", min: "member", want: false},
+		{assoc: "FIRST_TIME_CONTRIBUTOR", min: "first_timer", want: true},
+		{assoc: "NONE", min: "first_timer", want: false},
+		{assoc: "MANNEQUIN", min: "none", want: true},
+	}
+	for _, tt := range tests {
+		if got := hasMinAssociation(tt.assoc, tt.min); got != tt.want {
+			t.Errorf("%s at least %s: got %t, want %t", tt.assoc, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestHandleCommentRejectedCommands(t *testing.T) {
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+	api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+	api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "lint")},
+		map[int64][]*github.WorkflowRun{
+			1: {testRun(10, 1, testHeadSHA, failureConclusion)},
+			2: {testRun(20, 2, testHeadSHA, failureConclusion)},
+		})
+	api.handleReruns(10, 20)
+	api.handle(http.MethodPost, "/repos/o/r/issues/1/comments", http.StatusCreated, &github.IssueComment{})
+	h := newTestHandler(t, api)
+	h.postSummary = true
+	h.commandAssociations = map[string]string{retestAllWorkflowsCommand: "owner"}
+
+	comment := testComment(api, "/rerun-all\n/rerun-workflow build")
+	if err := h.handleComment(context.Background(), testOwner, testRepo, comment); err != nil {
+		t.Fatal(err)
+	}
+	// The member's rerun-workflow command is still run, but rerun-all is not.
+	if !api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun") || api.called(http.MethodPost, "/repos/o/r/actions/runs/20/rerun") {
+		t.Errorf("got reruns of 10: %t and 20: %t, want only 10",
+			api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"), api.called(http.MethodPost, "/repos/o/r/actions/runs/20/rerun"))
+	}
+	reported := false
+	for _, body := range api.requestBodies(http.MethodPost, "/repos/o/r/issues/1/comments") {
+		reported = reported || strings.Contains(body, "`/rerun-all` requires `owner` (commenter is `member`)")
+	}
+	if !reported {
+		t.Errorf("rejected command was not reported in a comment")
+	}
+
+	// A comment whose commands are all rejected is unauthorized, and still reported.
+	api = newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+	api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+	api.handle(http.MethodPost, "/repos/o/r/issues/1/comments", http.StatusCreated, &github.IssueComment{})
+	h = newTestHandler(t, api)
+	h.postSummary = true
+	h.commandAssociations = map[string]string{retestAllWorkflowsCommand: "owner"}
+	if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, "/rerun-all")); err != errUnauthorized {
+		t.Errorf("got error %v, want %v", err, errUnauthorized)
+	}
+	if !api.called(http.MethodPost, "/repos/o/r/issues/1/comments") {
+		t.Errorf("rejected command was not reported in a comment")
+	}
+}
+
+func TestAreRequiredChecksGreen(t *testing.T) {
+	const protectionPath = "/repos/o/r/branches/main/protection/required_status_checks"
+	status := func(context, state string) *github.RepoStatus {
+		return &github.RepoStatus{Context: github.String(context), State: github.String(state)}
+	}
+	checkRun := func(name, conclusion string) *github.CheckRun {
+		return &github.CheckRun{Name: github.String(name), Conclusion: github.String(conclusion)}
+	}
+	tests := []struct {
+		name      string
+		required  []string
+		statuses  []*github.RepoStatus
+		checkRuns []*github.CheckRun
+		want      bool
+	}{
+		{name: "no required checks"},
+		{name: "status passed", required: []string{"ci/build"}, statuses: []*github.RepoStatus{status("ci/build", "success")}, want: true},
+		{name: "check run passed", required: []string{"build"}, checkRuns: []*github.CheckRun{checkRun("build", "success")}, want: true},
+		{name: "check run skipped", required: []string{"build"}, checkRuns: []*github.CheckRun{checkRun("build", "skipped")}, want: true},
+		{
+			name:      "one failed",
+			required:  []string{"build", "lint"},
+			checkRuns: []*github.CheckRun{checkRun("build", "success"), checkRun("lint", "failure")},
+		},
+		{name: "status pending", required: []string{"ci/build"}, statuses: []*github.RepoStatus{status("ci/build", "pending")}},
+		{name: "not reported", required: []string{"build"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			if tt.required != nil {
+				api.handle(http.MethodGet, protectionPath, http.StatusOK, &github.RequiredStatusChecks{Contexts: tt.required})
+			}
+			api.handle(http.MethodGet, "/repos/o/r/commits/headsha/status", http.StatusOK, &github.CombinedStatus{Statuses: tt.statuses})
+			api.handle(http.MethodGet, "/repos/o/r/commits/headsha/check-runs", http.StatusOK,
+				&github.ListCheckRunsResults{Total: github.Int(len(tt.checkRuns)), CheckRuns: tt.checkRuns})
+			h := newTestHandler(t, api)
+
+			green, err := h.areRequiredChecksGreen(context.Background(), testOwner, testRepo, testPR())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if green != tt.want {
+				t.Errorf("got green %t, want %t", green, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleCommentSkipIfRequiredGreen(t *testing.T) {
+	for _, green := range []bool{true, false} {
+		t.Run(fmt.Sprintf("green %t", green), func(t *testing.T) {
+			conclusion := failureConclusion
+			if green {
+				conclusion = successfulConclusion
+			}
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10)
+			api.handle(http.MethodGet, "/repos/o/r/branches/main/protection/required_status_checks", http.StatusOK,
+				&github.RequiredStatusChecks{Contexts: []string{"build"}})
+			api.handle(http.MethodGet, "/repos/o/r/commits/headsha/status", http.StatusOK, &github.CombinedStatus{})
+			api.handle(http.MethodGet, "/repos/o/r/commits/headsha/check-runs", http.StatusOK, &github.ListCheckRunsResults{
+				CheckRuns: []*github.CheckRun{{Name: github.String("build"), Conclusion: github.String(conclusion)}},
+			})
+			h := newTestHandler(t, api)
+			h.skipIfRequiredGreen = true
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, "/rerun-all")); err != nil {
+				t.Fatal(err)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun == green {
+				t.Errorf("got rerun %t, want %t", rerun, !green)
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsSuccessWithin(t *testing.T) {
+	recent, old := testRun(10, 1, testHeadSHA, successfulConclusion), testRun(20, 2, testHeadSHA, successfulConclusion)
+	recent.UpdatedAt = &github.Timestamp{Time: time.Now().Add(-10 * time.Minute)}
+	old.UpdatedAt = &github.Timestamp{Time: time.Now().Add(-2 * time.Hour)}
+	tests := []struct {
+		name   string
+		within time.Duration
+		want   map[string]string
+	}{
+		{name: "unset", want: map[string]string{"build": outcomeSkippedSucceeded, "lint": outcomeSkippedSucceeded}},
+		{name: "set", within: time.Hour, want: map[string]string{"build": outcomeRerun, "lint": outcomeSkippedSucceeded}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "lint")},
+				map[int64][]*github.WorkflowRun{1: {recent}, 2: {old}})
+			api.handleReruns(10, 20)
+			h := newTestHandler(t, api)
+			h.rerunSuccessWithin = tt.within
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outcomes(results); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got outcomes %v, want %v", got, tt.want)
+			}
+			for _, result := range results {
+				if result.outcome == outcomeRerun && !reflect.DeepEqual(result.selections, []string{selectionHeadSHA, selectionRecentSuccess}) {
+					t.Errorf("got selections %v, want head SHA and recent success", result.selections)
+				}
+			}
+			if api.called(http.MethodPost, "/repos/o/r/actions/runs/20/rerun") {
+				t.Errorf("run that succeeded outside rerun_success_within was rerun")
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsSinceLastPush(t *testing.T) {
+	// Runs are created an hour after the PR; the head is committed before or after them.
+	tests := []struct {
+		name          string
+		sinceLastPush bool
+		committed     time.Duration
+		wantRerun     bool
+	}{
+		{name: "unset", committed: 2 * time.Hour, wantRerun: true},
+		{name: "run after push", sinceLastPush: true, committed: 30 * time.Minute, wantRerun: true},
+		{name: "run before push", sinceLastPush: true, committed: 2 * time.Hour},
+		{name: "committed before PR", sinceLastPush: true, committed: -time.Hour, wantRerun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10)
+			committed := testPRCreatedAt.Add(tt.committed)
+			api.handle(http.MethodGet, "/repos/o/r/git/commits/headsha", http.StatusOK,
+				&github.Commit{SHA: github.String(testHeadSHA), Committer: &github.CommitAuthor{Date: &committed}})
+			h := newTestHandler(t, api)
+			h.sinceLastPush = tt.sinceLastPush
+
+			if _, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, true); err != nil {
+				t.Fatal(err)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != tt.wantRerun {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantRerun)
+			}
+			if fetched := api.called(http.MethodGet, "/repos/o/r/git/commits/headsha"); fetched != tt.sinceLastPush {
+				t.Errorf("got head commit fetched %t, want %t", fetched, tt.sinceLastPush)
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsRerunAllScope(t *testing.T) {
+	workflows := []*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "lint"), testWorkflow(3, "docs")}
+	runs := map[int64][]*github.WorkflowRun{
+		1: {testRun(10, 1, testHeadSHA, failureConclusion)},
+		2: {testRun(20, 2, testHeadSHA, failureConclusion)},
+		3: {testRun(30, 3, testHeadSHA, failureConclusion)},
+	}
+	tests := []struct {
+		scope      string
+		wantReruns []int64
+	}{
+		{scope: rerunAllScopeAll, wantReruns: []int64{10, 20, 30}},
+		// Only build's run has a job reporting a required check.
+		{scope: rerunAllScopeRequired, wantReruns: []int64{10}},
+		// lint is blocklisted.
+		{scope: rerunAllScopeActiveNonBlocklisted, wantReruns: []int64{10, 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows(workflows, runs)
+			api.handleReruns(10, 20, 30)
+			api.handle(http.MethodGet, "/repos/o/r/branches/main/protection/required_status_checks", http.StatusOK,
+				&github.RequiredStatusChecks{Contexts: []string{"build"}})
+			for _, workflow := range workflows {
+				api.handle(http.MethodGet, fmt.Sprintf("/repos/o/r/actions/runs/%d/jobs", workflow.GetID()*10), http.StatusOK,
+					&github.Jobs{Jobs: []*github.WorkflowJob{{Name: workflow.Name}}})
+			}
+			h := newTestHandler(t, api)
+			h.rerunAllScope = tt.scope
+			h.workflowBlocklist = map[string]struct{}{"lint": {}}
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tt.wantReruns) {
+				t.Errorf("got outcomes %v, want %d reruns", outcomes(results), len(tt.wantReruns))
+			}
+			for _, id := range []int64{10, 20, 30} {
+				rerun := api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id))
+				if want := containsID(tt.wantReruns, id); rerun != want {
+					t.Errorf("run %d rerun: got %t, want %t", id, rerun, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsApprove(t *testing.T) {
+	tests := []struct {
+		name        string
+		privileged  bool
+		status      int
+		want        string
+		wantApprove bool
+	}{
+		{name: "privileged", privileged: true, status: http.StatusCreated, want: outcomeApproved, wantApprove: true},
+		{name: "approve fails", privileged: true, status: http.StatusInternalServerError, want: outcomeApproveFailed, wantApprove: true},
+		{name: "unprivileged", status: http.StatusCreated, want: outcomeAwaitingApproval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, actionRequired)}})
+			api.handleReruns(10)
+			api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/approve", tt.status, nil)
+			h := newTestHandler(t, api)
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, tt.privileged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outcomes(results)["build"]; got != tt.want {
+				t.Errorf("got outcome %q, want %q", got, tt.want)
+			}
+			if approved := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/approve"); approved != tt.wantApprove {
+				t.Errorf("got approve %t, want %t", approved, tt.wantApprove)
+			}
+			if api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun") {
+				t.Errorf("run awaiting approval was rerun")
+			}
+		})
+	}
+}
+
+func TestGetPRStack(t *testing.T) {
+	// stackedPR returns a PR from head onto base.
+	stackedPR := func(num int, head, base string) *github.PullRequest {
+		pr := testPR()
+		pr.Number = github.Int(num)
+		pr.Head.Ref, pr.Base.Ref = github.String(head), github.String(base)
+		return pr
+	}
+	tests := []struct {
+		name      string
+		pr        *github.PullRequest
+		basePRs   [][]*github.PullRequest
+		want      []int
+		wantHeads []string
+	}{
+		{name: "onto default branch", pr: stackedPR(1, "c", "main"), want: []int{1}},
+		{
+			name:      "stacked",
+			pr:        stackedPR(1, "c", "b"),
+			basePRs:   [][]*github.PullRequest{{stackedPR(2, "b", "a")}, {stackedPR(3, "a", "main")}},
+			want:      []int{1, 2, 3},
+			wantHeads: []string{"o:b", "o:a"},
+		},
+		{
+			name:      "base branch without PR",
+			pr:        stackedPR(1, "c", "b"),
+			basePRs:   [][]*github.PullRequest{{}},
+			want:      []int{1},
+			wantHeads: []string{"o:b"},
+		},
+		{
+			name: "max depth",
+			pr:   stackedPR(1, "b1", "b2"),
+			basePRs: [][]*github.PullRequest{
+				{stackedPR(2, "b2", "b3")}, {stackedPR(3, "b3", "b4")}, {stackedPR(4, "b4", "b5")},
+				{stackedPR(5, "b5", "b6")}, {stackedPR(6, "b6", "b7")},
+			},
+			want:      []int{1, 2, 3, 4, 5},
+			wantHeads: []string{"o:b2", "o:b3", "o:b4", "o:b5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			for _, prs := range tt.basePRs {
+				api.handleOnce(http.MethodGet, "/repos/o/r/pulls", http.StatusOK, prs)
+			}
+			h := newTestHandler(t, api)
+
+			stack, err := h.getPRStack(context.Background(), testOwner, testRepo, tt.pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for _, pr := range stack {
+				got = append(got, pr.GetNumber())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got stack %v, want %v", got, tt.want)
+			}
+			var heads []string
+			for _, req := range api.requests {
+				heads = append(heads, req.URL.Query().Get("head"))
+			}
+			if !reflect.DeepEqual(heads, tt.wantHeads) {
+				t.Errorf("got listed heads %v, want %v", heads, tt.wantHeads)
+			}
+		})
+	}
+}
+
+func TestHandleCommentRequireOrgMembership(t *testing.T) {
+	const path = "/orgs/o/members/maintainer"
+	tests := []struct {
+		name      string
+		status    int
+		wantErr   error
+		wantRerun bool
+	}{
+		{name: "member", status: http.StatusNoContent, wantRerun: true},
+		{name: "outside collaborator", status: http.StatusNotFound, wantErr: errUnauthorized},
+		// API errors are logged rather than treated as rejections.
+		{name: "API error", status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10)
+			api.handle(http.MethodGet, path, tt.status, nil)
+			h := newTestHandler(t, api)
+			h.requireOrgMembership = true
+			comment := testComment(api, "/rerun-all")
+			comment.AuthorAssociation = github.String("COLLABORATOR")
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, comment); err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != tt.wantRerun {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantRerun)
+			}
+			if !api.called(http.MethodGet, path) {
+				t.Errorf("org membership was not checked")
+			}
+		})
+	}
+}
+
+func TestIsOrgMemberCache(t *testing.T) {
+	const path = "/orgs/o/members/alice"
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, path, http.StatusNoContent, nil)
+	h := newTestHandler(t, api)
+
+	for i := 0; i < 2; i++ {
+		if isMember, err := h.isOrgMember(context.Background(), testOwner, "alice"); err != nil || !isMember {
+			t.Fatalf("check %d: got member %t error %v, want true", i, isMember, err)
+		}
+	}
+	if calls := api.calls(http.MethodGet, path); calls != 1 {
+		t.Errorf("got %d membership requests, want 1", calls)
+	}
+}
+
+func TestHandleCommentRemoveOkToTest(t *testing.T) {
+	const labelPath = "/repos/o/r/issues/1/labels/ok-to-test"
+	tests := []struct {
+		name        string
+		association string
+		labeled     bool
+		wantRemove  bool
+	}{
+		{name: "privileged", association: "MEMBER", labeled: true, wantRemove: true},
+		{name: "unprivileged", association: "NONE", labeled: true},
+		{name: "label absent", association: "MEMBER"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issue := testIssue()
+			if tt.labeled {
+				issue.Labels = []*github.Label{{Name: github.String(canTestLabel)}}
+			}
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, issue)
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handle(http.MethodDelete, labelPath, http.StatusOK, nil)
+			h := newTestHandler(t, api)
+			comment := testComment(api, "/remove-ok-to-test")
+			comment.AuthorAssociation = github.String(tt.association)
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, comment); err != nil {
+				t.Fatal(err)
+			}
+			if removed := api.called(http.MethodDelete, labelPath); removed != tt.wantRemove {
+				t.Errorf("got label removed %t, want %t", removed, tt.wantRemove)
+			}
+		})
+	}
+}
+
+func TestHandleCommentMinSettle(t *testing.T) {
+	tests := []struct {
+		name      string
+		pushed    time.Duration
+		wantErr   error
+		wantRerun bool
+	}{
+		{name: "fresh push", pushed: time.Minute, wantErr: errSettling},
+		{name: "settled", pushed: time.Hour, wantRerun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			committed := time.Now().Add(-tt.pushed)
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handle(http.MethodGet, "/repos/o/r/git/commits/headsha", http.StatusOK,
+				&github.Commit{SHA: github.String(testHeadSHA), Committer: &github.CommitAuthor{Date: &committed}})
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10)
+			api.handle(http.MethodPost, "/repos/o/r/issues/1/comments", http.StatusCreated, &github.IssueComment{})
+			h := newTestHandler(t, api)
+			h.minSettle = 10 * time.Minute
+			h.postSummary = true
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, "/rerun-all")); err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != tt.wantRerun {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantRerun)
+			}
+			if tt.wantErr == errSettling {
+				var reply github.IssueComment
+				api.body(t, http.MethodPost, "/repos/o/r/issues/1/comments", &reply)
+				if !strings.Contains(reply.GetBody(), "still starting") {
+					t.Errorf("got reply %q, want a settling explanation", reply.GetBody())
+				}
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsPermissionDenied(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		want   string
+	}{
+		{name: "forbidden", status: http.StatusForbidden, want: outcomePermissionDenied},
+		{
+			name:   "rate limited",
+			status: http.StatusForbidden,
+			header: http.Header{"X-Ratelimit-Limit": {"5000"}, "X-Ratelimit-Remaining": {"0"}},
+			want:   outcomeRerunFailed,
+		},
+		{name: "server error", status: http.StatusInternalServerError, want: outcomeRerunFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handlePage(http.MethodPost, "/repos/o/r/actions/runs/10/rerun", tt.status,
+				map[string]string{"message": "Resource not accessible by integration"}, tt.header)
+			api.handle(http.MethodPost, "/repos/o/r/issues/1/comments", http.StatusCreated, &github.IssueComment{})
+			h := newTestHandler(t, api)
+			h.postSummary = true
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outcomes(results)["build"]; got != tt.want {
+				t.Errorf("got outcome %q, want %q", got, tt.want)
+			}
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, "/rerun-all")); err != nil {
+				t.Fatal(err)
+			}
+			explained := false
+			for _, body := range api.requestBodies(http.MethodPost, "/repos/o/r/issues/1/comments") {
+				explained = explained || strings.Contains(body, "actions: write")
+			}
+			if want := tt.want == outcomePermissionDenied; explained != want {
+				t.Errorf("got permissions explained %t, want %t", explained, want)
+			}
+		})
+	}
+}
+
+func TestHandleCommentNoCommandOutput(t *testing.T) {
+	tests := []struct {
+		body         string
+		want         string
+		wantRequests bool
+	}{
+		{body: "looks good to me", want: "true"},
+		{body: "/rerun-all", want: "false", wantRequests: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10)
+			h := newTestHandler(t, api)
+			out := &bytes.Buffer{}
+			h.Action = actions.NewWithWriter(out)
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, tt.body)); err != nil && err != errNoCommand {
+				t.Fatal(err)
+			}
+			if want := "::set-output name=no_command::" + tt.want; !strings.Contains(out.String(), want) {
+				t.Errorf("got output %q, want %q", out.String(), want)
+			}
+			// Comments without commands take the fast path, which makes no API calls.
+			if requested := len(api.requests) != 0; requested != tt.wantRequests {
+				t.Errorf("got API requests %t, want %t", requested, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestCommentSample(t *testing.T) {
+	long := strings.Repeat("a", maxCommentSample+1)
+	tests := []struct {
+		body string
+		want string
+	}{
+		{body: "", want: ""},
+		{body: "looks good", want: "looks good"},
+		{body: "looks good\nbut rerun", want: "looks good"},
+		{body: long, want: long[:maxCommentSample] + "..."},
+	}
+	for _, tt := range tests {
+		if got := commentSample(tt.body); got != tt.want {
+			t.Errorf("commentSample(%q): got %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestRerunPRWorkflowsDispatchSkipped(t *testing.T) {
+	const dispatchPath = "/repos/o/r/actions/workflows/1/dispatches"
+	fork := testPR()
+	fork.Head.Repo = &github.Repository{FullName: github.String("contributor/r")}
+	tests := []struct {
+		name         string
+		conclusion   string
+		pr           *github.PullRequest
+		privileged   bool
+		status       int
+		want         string
+		wantDispatch bool
+		wantRerun    bool
+	}{
+		{name: "dispatched", conclusion: skippedConclusion, privileged: true, status: http.StatusNoContent, want: outcomeDispatched, wantDispatch: true},
+		{
+			name: "no workflow_dispatch trigger", conclusion: skippedConclusion, privileged: true,
+			status: http.StatusUnprocessableEntity, want: outcomeSkippedNoDispatchTrigger, wantDispatch: true,
+		},
+		{name: "dispatch fails", conclusion: skippedConclusion, privileged: true, status: http.StatusInternalServerError, want: outcomeDispatchFailed, wantDispatch: true},
+		{name: "unprivileged", conclusion: skippedConclusion, status: http.StatusNoContent, want: outcomeSkippedNoDispatch},
+		{name: "fork", conclusion: skippedConclusion, pr: fork, privileged: true, status: http.StatusNoContent, want: outcomeSkippedFork},
+		{name: "failed run", conclusion: failureConclusion, privileged: true, status: http.StatusNoContent, want: outcomeRerun, wantRerun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := tt.pr
+			if pr == nil {
+				pr = testPR()
+			}
+			api := newFakeAPI(t)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, tt.conclusion)}})
+			api.handleReruns(10)
+			api.handle(http.MethodPost, dispatchPath, tt.status, nil)
+			h := newTestHandler(t, api)
+			testsToRerun := commandsToWorkflowNames(h.parser.parseCommands("/rerun-skipped build"))
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, pr, testsToRerun, tt.privileged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outcomes(results)["build"]; got != tt.want {
+				t.Errorf("got outcome %q, want %q", got, tt.want)
+			}
+			if dispatched := api.called(http.MethodPost, dispatchPath); dispatched != tt.wantDispatch {
+				t.Fatalf("got dispatch %t, want %t", dispatched, tt.wantDispatch)
+			}
+			if tt.wantDispatch {
+				var event github.CreateWorkflowDispatchEventRequest
+				api.body(t, http.MethodPost, dispatchPath, &event)
+				if event.Ref != "feature" {
+					t.Errorf("got dispatch ref %q, want the head branch %q", event.Ref, "feature")
+				}
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != tt.wantRerun {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantRerun)
+			}
+		})
+	}
+}
+
+func TestHandleCommentEarlyAck(t *testing.T) {
+	tests := []struct {
+		name        string
+		issueStatus int
+		want        string
+	}{
+		{name: "succeeded", issueStatus: http.StatusOK, want: reactionSucceeded},
+		{name: "failed", issueStatus: http.StatusInternalServerError, want: reactionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", tt.issueStatus, testIssue())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10)
+			api.handle(http.MethodPost, testReactionsPath, http.StatusCreated, &github.Reaction{ID: github.Int64(7)})
+			api.handle(http.MethodDelete, testReactionsPath+"/7", http.StatusNoContent, nil)
+			h := newTestHandler(t, api)
+			h.earlyAck = true
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, "/rerun-all")); err != nil {
+				t.Fatal(err)
+			}
+			if len(api.requests) == 0 || api.requests[0].Method != http.MethodPost || api.requests[0].URL.Path != testReactionsPath {
+				t.Fatalf("first request was not the acknowledgement reaction")
+			}
+			if contents, want := reactionContents(t, api), []string{reactionReceived, tt.want}; !reflect.DeepEqual(contents, want) {
+				t.Errorf("got reactions %v, want %v", contents, want)
+			}
+			if !api.called(http.MethodDelete, testReactionsPath+"/7") {
+				t.Errorf("acknowledgement reaction was not removed")
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsDispatchStale(t *testing.T) {
+	headRepo := &github.Repository{FullName: github.String(testOwner + "/" + testRepo)}
+	branchRun := func(id, workflowID int64, sha string, repo *github.Repository) *github.WorkflowRun {
+		run := testRun(id, workflowID, sha, successfulConclusion)
+		run.HeadRepository = repo
+		return run
+	}
+	workflows := []*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "lint"), testWorkflow(3, "docs"), testWorkflow(4, "e2e")}
+	api := newFakeAPI(t)
+	api.handleWorkflows(workflows, map[int64][]*github.WorkflowRun{
+		1: {branchRun(10, 1, testHeadSHA, headRepo)},
+		2: {branchRun(20, 2, "oldsha", headRepo)},
+		// Only a fork's same-named branch ran e2e.
+		4: {branchRun(40, 4, "forksha", &github.Repository{FullName: github.String("contributor/r")})},
+	})
+	for _, workflow := range workflows {
+		api.handle(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/workflows/%d/dispatches", workflow.GetID()), http.StatusNoContent, nil)
+	}
+	h := newTestHandler(t, api)
+
+	results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testStale: {}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"build": outcomeSkippedOnHead, "lint": outcomeDispatched}
+	if got := outcomes(results); !reflect.DeepEqual(got, want) {
+		t.Errorf("got outcomes %v, want %v", got, want)
+	}
+	for _, workflow := range workflows {
+		dispatched := api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/workflows/%d/dispatches", workflow.GetID()))
+		if want := workflow.GetName() == "lint"; dispatched != want {
+			t.Errorf("workflow %s dispatched: got %t, want %t", workflow.GetName(), dispatched, want)
+		}
+	}
+	for _, req := range api.requests {
+		if req.Method == http.MethodGet && strings.HasSuffix(req.URL.Path, "/runs") && req.URL.Query().Get("branch") != "feature" {
+			t.Errorf("got runs listed for branch %q, want %q", req.URL.Query().Get("branch"), "feature")
+		}
+	}
+}
+
+func TestWarnUnmatchedWorkflows(t *testing.T) {
+	workflows := []*github.Workflow{testWorkflow(1, "lint"), testWorkflow(2, "build")}
+	tests := []struct {
+		name         string
+		testsToRerun map[string]rerunOptions
+		verbose      bool
+		want         string
+	}{
+		{name: "matched", testsToRerun: map[string]rerunOptions{"build": {}}},
+		{name: "unmatched", testsToRerun: map[string]rerunOptions{"biuld": {}}, want: "::warning::No workflow named \"biuld\" found\n"},
+		{
+			name:         "verbose",
+			testsToRerun: map[string]rerunOptions{"biuld": {}},
+			verbose:      true,
+			want:         "::warning::No workflow named \"biuld\" found (available workflows: build, lint)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			h := &handler{Action: actions.NewWithWriter(out), verbose: tt.verbose}
+			h.warnUnmatchedWorkflows(tt.testsToRerun, workflows)
+			if out.String() != tt.want {
+				t.Errorf("got output %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsCollidingNames(t *testing.T) {
+	build, otherBuild := testWorkflow(1, "build"), testWorkflow(2, "build")
+	otherBuild.Path = github.String(".github/workflows/other-build.yaml")
+	api := newFakeAPI(t)
+	// The first workflow's runs include one attributed to the second, which must not be rerun as the first's.
+	api.handleWorkflows([]*github.Workflow{build, otherBuild}, map[int64][]*github.WorkflowRun{
+		1: {testRun(21, 2, testHeadSHA, failureConclusion), testRun(10, 1, testHeadSHA, failureConclusion)},
+		2: {testRun(20, 2, testHeadSHA, failureConclusion)},
+	})
+	api.handleReruns(10, 20, 21)
+	h := newTestHandler(t, api)
+
+	results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{"build": {}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, result := range results {
+		got = append(got, result.run.GetID())
+	}
+	if want := []int64{10, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got runs %v, want %v", got, want)
+	}
+	if api.called(http.MethodPost, "/repos/o/r/actions/runs/21/rerun") {
+		t.Errorf("run of another workflow was rerun")
+	}
+}
+
+func TestRerunPRWorkflowsGroup(t *testing.T) {
+	workflows := []*github.Workflow{testWorkflow(1, "e2e-aws"), testWorkflow(2, "e2e-gcp"), testWorkflow(3, "smoke"), testWorkflow(4, "build")}
+	runs := map[int64][]*github.WorkflowRun{
+		1: {testRun(10, 1, testHeadSHA, failureConclusion)},
+		2: {testRun(20, 2, testHeadSHA, successfulConclusion)},
+		3: {testIncompleteRun(30, 3)},
+		4: {testRun(40, 4, testHeadSHA, failureConclusion)},
+	}
+	tests := []struct {
+		name       string
+		body       string
+		want       map[string]string
+		wantReruns []int64
+	}{
+		{
+			name: "group",
+			body: "/rerun-group e2e",
+			want: map[string]string{
+				"e2e-aws": outcomeRerun, "e2e-gcp": outcomeSkippedSucceeded, "smoke": outcomeSkippedIncomplete,
+			},
+			wantReruns: []int64{10},
+		},
+		{name: "unknown group", body: "/rerun-group unit", want: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows(workflows, runs)
+			api.handleReruns(10, 20, 30, 40)
+			api.handle(http.MethodPost, "/repos/o/r/actions/runs/30/cancel", http.StatusAccepted, nil)
+			h := newTestHandler(t, api)
+			h.workflowGroups = map[string][]string{"e2e": {"e2e-*", "smoke"}}
+			testsToRerun := commandsToWorkflowNames(h.parser.parseCommands(tt.body))
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), testsToRerun, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outcomes(results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got outcomes %v, want %v", got, tt.want)
+			}
+			for _, id := range []int64{10, 20, 30, 40} {
+				rerun := api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id))
+				if want := containsID(tt.wantReruns, id); rerun != want {
+					t.Errorf("run %d rerun: got %t, want %t", id, rerun, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsMatchMergeRef(t *testing.T) {
+	tests := []struct {
+		name          string
+		matchMergeRef bool
+		mergeSHA      string
+		mergeable     *bool
+		wantRerun     bool
+	}{
+		{name: "unset", mergeSHA: "mergesha", mergeable: github.Bool(true)},
+		{name: "merge ref", matchMergeRef: true, mergeSHA: "mergesha", mergeable: github.Bool(true), wantRerun: true},
+		{name: "no merge ref", matchMergeRef: true, mergeable: github.Bool(true)},
+		{name: "mergeability computing", matchMergeRef: true, mergeSHA: "mergesha"},
+		{name: "unmergeable", matchMergeRef: true, mergeSHA: "mergesha", mergeable: github.Bool(false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := testPR()
+			if tt.mergeSHA != "" {
+				pr.MergeCommitSHA = github.String(tt.mergeSHA)
+			}
+			pr.Mergeable = tt.mergeable
+			api := newFakeAPI(t)
+			// Only the merge ref has a run.
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, "mergesha", failureConclusion)}})
+			api.handleReruns(10)
+			h := newTestHandler(t, api)
+			h.matchMergeRef = tt.matchMergeRef
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, pr, map[string]rerunOptions{testAll: {}}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != tt.wantRerun {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantRerun)
+			}
+			if tt.wantRerun && (len(results) != 1 || !reflect.DeepEqual(results[0].selections, []string{selectionMergeRefSHA})) {
+				t.Errorf("got results %+v, want one selected by merge ref SHA", results)
+			}
+		})
+	}
+}
+
+func TestHandleCommentFirstTimerApproval(t *testing.T) {
+	review := func(login, association, state string) *github.PullRequestReview {
+		return &github.PullRequestReview{
+			User:              &github.User{Login: github.String(login)},
+			AuthorAssociation: github.String(association),
+			State:             github.String(state),
+		}
+	}
+	tests := []struct {
+		name               string
+		association        string
+		firstTimerApproval bool
+		reviews            []*github.PullRequestReview
+		wantErr            error
+		wantReviews        bool
+	}{
+		{name: "unset", association: "FIRST_TIME_CONTRIBUTOR"},
+		{name: "not a first-timer", association: "NONE", firstTimerApproval: true},
+		{name: "unapproved", association: "FIRST_TIME_CONTRIBUTOR", firstTimerApproval: true, wantErr: errUnauthorized, wantReviews: true},
+		{
+			name: "approved", association: "FIRST_TIMER", firstTimerApproval: true, wantReviews: true,
+			reviews: []*github.PullRequestReview{review("alice", "MEMBER", "APPROVED"), review("alice", "MEMBER", "COMMENTED")},
+		},
+		{
+			name: "approval withdrawn", association: "FIRST_TIME_CONTRIBUTOR", firstTimerApproval: true, wantErr: errUnauthorized, wantReviews: true,
+			reviews: []*github.PullRequestReview{review("alice", "MEMBER", "APPROVED"), review("alice", "MEMBER", "CHANGES_REQUESTED")},
+		},
+		{
+			name: "approved by unprivileged reviewer", association: "FIRST_TIME_CONTRIBUTOR", firstTimerApproval: true, wantErr: errUnauthorized,
+			wantReviews: true, reviews: []*github.PullRequestReview{review("bob", "NONE", "APPROVED")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issue := testIssue()
+			issue.Labels = []*github.Label{{Name: github.String(canTestLabel)}}
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, issue)
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1/reviews", http.StatusOK, tt.reviews)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10)
+			h := newTestHandler(t, api)
+			h.firstTimerApproval = tt.firstTimerApproval
+			comment := testComment(api, "/rerun-all")
+			comment.AuthorAssociation = github.String(tt.association)
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, comment); err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != (tt.wantErr == nil) {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantErr == nil)
+			}
+			if checked := api.called(http.MethodGet, "/repos/o/r/pulls/1/reviews"); checked != tt.wantReviews {
+				t.Errorf("got reviews checked %t, want %t", checked, tt.wantReviews)
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsExcludeEvents(t *testing.T) {
+	scheduled := testRun(11, 1, testHeadSHA, failureConclusion)
+	scheduled.Event = github.String("schedule")
+	tests := []struct {
+		name          string
+		excludeEvents map[string]struct{}
+		wantRerun     int64
+	}{
+		{name: "included", wantRerun: 11},
+		{name: "excluded", excludeEvents: map[string]struct{}{"schedule": {}}, wantRerun: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {scheduled, testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10, 11)
+			h := newTestHandler(t, api)
+			h.runEvents = map[string]struct{}{defaultRunEvent: {}, "schedule": {}}
+			h.excludeEvents = tt.excludeEvents
+
+			if _, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, true); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []int64{10, 11} {
+				if rerun := api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id)); rerun != (id == tt.wantRerun) {
+					t.Errorf("run %d rerun: got %t, want %t", id, rerun, id == tt.wantRerun)
+				}
+			}
+			// Runs of several events are listed unfiltered, then matched by event.
+			for _, req := range api.requests {
+				if event := req.URL.Query().Get("event"); event != "" {
+					t.Errorf("got runs listed for event %q, want all events", event)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleCommentHeadRepoDeleted(t *testing.T) {
+	pr := testPR()
+	pr.Head.Repo = nil
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, testIssue())
+	api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, pr)
+	api.handle(http.MethodPost, "/repos/o/r/issues/1/comments", http.StatusCreated, &github.IssueComment{})
+	h := newTestHandler(t, api)
+	h.postSummary = true
+
+	if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, "/rerun-all")); err != errHeadRepoDeleted {
+		t.Fatalf("got error %v, want %v", err, errHeadRepoDeleted)
+	}
+	var reply github.IssueComment
+	api.body(t, http.MethodPost, "/repos/o/r/issues/1/comments", &reply)
+	if !strings.Contains(reply.GetBody(), "head repository was deleted") {
+		t.Errorf("got reply %q, want an explanation that the head repo was deleted", reply.GetBody())
+	}
+	if api.called(http.MethodGet, "/repos/o/r/actions/workflows") {
+		t.Errorf("workflows were listed for a PR without a head repo")
+	}
+}
+
+func TestRerunPRWorkflowsCheck(t *testing.T) {
+	checkRun := func(slug string) *github.CheckRun {
+		return &github.CheckRun{ID: github.Int64(500), Name: github.String("unit"), App: &github.App{Slug: github.String(slug)}}
+	}
+	tests := []struct {
+		name       string
+		checkRuns  []*github.CheckRun
+		want       map[string]string
+		wantReruns []int64
+	}{
+		{name: "actions check", checkRuns: []*github.CheckRun{checkRun(actionsAppSlug)}, want: map[string]string{"test": outcomeRerun}, wantReruns: []int64{20}},
+		{name: "other app", checkRuns: []*github.CheckRun{checkRun("circleci")}, want: map[string]string{}},
+		{name: "no check", want: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "test")}, map[int64][]*github.WorkflowRun{
+				1: {testRun(10, 1, testHeadSHA, failureConclusion)},
+				2: {testRun(20, 2, testHeadSHA, failureConclusion)},
+			})
+			api.handleReruns(10, 20)
+			api.handle(http.MethodGet, "/repos/o/r/commits/headsha/check-runs", http.StatusOK,
+				&github.ListCheckRunsResults{Total: github.Int(len(tt.checkRuns)), CheckRuns: tt.checkRuns})
+			api.handle(http.MethodGet, "/repos/o/r/actions/jobs/500", http.StatusOK, &github.WorkflowJob{ID: github.Int64(500), RunID: github.Int64(20)})
+			api.handle(http.MethodGet, "/repos/o/r/actions/runs/20", http.StatusOK, testRun(20, 2, testHeadSHA, failureConclusion))
+			h := newTestHandler(t, api)
+			testsToRerun := commandsToWorkflowNames(h.parser.parseCommands("/rerun-check unit"))
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), testsToRerun, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outcomes(results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got outcomes %v, want %v", got, tt.want)
+			}
+			for _, id := range []int64{10, 20} {
+				rerun := api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id))
+				if want := containsID(tt.wantReruns, id); rerun != want {
+					t.Errorf("run %d rerun: got %t, want %t", id, rerun, want)
+				}
+			}
+			for _, req := range api.requests {
+				if strings.HasSuffix(req.URL.Path, "/check-runs") && req.URL.Query().Get("check_name") != "unit" {
+					t.Errorf("got check runs listed by name %q, want %q", req.URL.Query().Get("check_name"), "unit")
+				}
+			}
+		})
+	}
+}
+
+func TestRepoTokenMasked(t *testing.T) {
+	defer setTestEnv(t, map[string]string{"INPUT_REPO_TOKEN": "ghp_secret"})()
+	out := &bytes.Buffer{}
+	h := &handler{Action: actions.NewWithWriter(out)}
+
+	if token := h.repoToken(); token != "ghp_secret" {
+		t.Errorf("got token %q, want %q", token, "ghp_secret")
+	}
+	// The runner redacts masked values from all later log lines.
+	if want := "::add-mask::ghp_secret\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+}
+
+func TestHandleCommentReportApprovalNeeded(t *testing.T) {
+	tests := []struct {
+		name                 string
+		association          string
+		reportApprovalNeeded bool
+		wantReport           bool
+	}{
+		{name: "unprivileged", association: "NONE", reportApprovalNeeded: true, wantReport: true},
+		{name: "privileged", association: "MEMBER", reportApprovalNeeded: true},
+		{name: "unset", association: "NONE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issue := testIssue()
+			issue.Labels = []*github.Label{{Name: github.String(canTestLabel)}}
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, issue)
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, testPR())
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "lint")}, map[int64][]*github.WorkflowRun{
+				1: {testRun(10, 1, testHeadSHA, actionRequired)},
+				2: {testRun(20, 2, testHeadSHA, failureConclusion)},
+			})
+			api.handleReruns(10, 20)
+			api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/approve", http.StatusCreated, nil)
+			api.handle(http.MethodPost, "/repos/o/r/issues/1/comments", http.StatusCreated, &github.IssueComment{})
+			h := newTestHandler(t, api)
+			h.reportApprovalNeeded = tt.reportApprovalNeeded
+			comment := testComment(api, "/rerun-all")
+			comment.AuthorAssociation = github.String(tt.association)
+			comment.User.Login = github.String("contributor")
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, comment); err != nil {
+				t.Fatal(err)
+			}
+			if reported := api.called(http.MethodPost, "/repos/o/r/issues/1/comments"); reported != tt.wantReport {
+				t.Fatalf("got report %t, want %t", reported, tt.wantReport)
+			}
+			if tt.wantReport {
+				var reply github.IssueComment
+				api.body(t, http.MethodPost, "/repos/o/r/issues/1/comments", &reply)
+				if body := reply.GetBody(); !strings.HasPrefix(body, "@contributor") || !strings.Contains(body, "- build\n") ||
+					strings.Contains(body, "- lint\n") {
+					t.Errorf("got report %q, want only build listed for @contributor", body)
+				}
+			}
+		})
+	}
+}
+
+func TestIsSelfWorkflow(t *testing.T) {
+	// This action runs in a reusable workflow called by the "caller" workflow.
+	defer setTestEnv(t, map[string]string{"GITHUB_WORKFLOW": "caller"})()
+	self := testWorkflow(1, "rerun")
+	self.Path = github.String(".github/workflows/rerun.yaml")
+	tests := []struct {
+		name             string
+		selfWorkflowPath string
+		workflow         *github.Workflow
+		want             bool
+	}{
+		{name: "unset", workflow: self},
+		{name: "path", selfWorkflowPath: "./.github/workflows/rerun.yaml", workflow: self, want: true},
+		{name: "other path", selfWorkflowPath: ".github/workflows/rerun.yaml", workflow: testWorkflow(2, "build")},
+		{name: "named by GITHUB_WORKFLOW", selfWorkflowPath: ".github/workflows/rerun.yaml", workflow: testWorkflow(3, "caller"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, errs := loadTestInputs(t, map[string]string{"INPUT_SELF_WORKFLOW_PATH": tt.selfWorkflowPath})
+			if len(errs) != 0 {
+				t.Fatal(errs)
+			}
+			if got := h.isSelfWorkflow(tt.workflow); got != tt.want {
+				t.Errorf("got self %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssueURLNumber(t *testing.T) {
+	tests := []struct {
+		issueURL string
+		want     int
+		wantOK   bool
+	}{
+		{issueURL: "https://api.github.com/repos/o/r/issues/12", want: 12, wantOK: true},
+		{issueURL: "https://api.github.com/repos/o/issues/r/issues/3", want: 3, wantOK: true},
+		{issueURL: "https://api.github.com/repos/o/r/pulls/12"},
+		{issueURL: "https://api.github.com/repos/o/r/issues/12/comments"},
+		{issueURL: ""},
+	}
+	for _, tt := range tests {
+		num, ok := issueURLNumber(tt.issueURL)
+		if num != tt.want || ok != tt.wantOK {
+			t.Errorf("issueURLNumber(%q): got %d %t, want %d %t", tt.issueURL, num, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestHandleCommentIssueMismatch(t *testing.T) {
+	otherIssue, otherPR := testIssue(), testPR()
+	otherIssue.Number, otherPR.Number = github.Int(2), github.Int(2)
+	tests := []struct {
+		name  string
+		issue *github.Issue
+		pr    *github.PullRequest
+	}{
+		{name: "issue", issue: otherIssue, pr: testPR()},
+		{name: "PR", issue: testIssue(), pr: otherPR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodGet, "/repos/o/r/issues/1", http.StatusOK, tt.issue)
+			api.handle(http.MethodGet, "/repos/o/r/pulls/1", http.StatusOK, tt.pr)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testRun(10, 1, testHeadSHA, failureConclusion)}})
+			api.handleReruns(10)
+			h := newTestHandler(t, api)
+
+			if err := h.handleComment(context.Background(), testOwner, testRepo, testComment(api, "/rerun-all")); err != errIssueMismatch {
+				t.Errorf("got error %v, want %v", err, errIssueMismatch)
+			}
+			if api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun") {
+				t.Errorf("run was rerun for a mismatched PR")
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsSelections(t *testing.T) {
+	workflows := []*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "lint"), testWorkflow(3, "docs")}
+	recent := testRun(30, 3, testHeadSHA, successfulConclusion)
+	recent.UpdatedAt = &github.Timestamp{Time: time.Now()}
+	pr := testPR()
+	pr.MergeCommitSHA, pr.Mergeable = github.String("mergesha"), github.Bool(true)
+	api := newFakeAPI(t)
+	api.handleWorkflows(workflows, map[int64][]*github.WorkflowRun{
+		1: {testRun(10, 1, testHeadSHA, failureConclusion)},
+		2: {testRun(20, 2, "mergesha", failureConclusion)},
+		3: {recent},
+	})
+	api.handleReruns(10, 20, 30)
+	api.handle(http.MethodGet, "/repos/o/r/branches/main/protection/required_status_checks", http.StatusOK,
+		&github.RequiredStatusChecks{Contexts: []string{"build", "lint", "docs"}})
+	for _, workflow := range workflows {
+		api.handle(http.MethodGet, fmt.Sprintf("/repos/o/r/actions/runs/%d/jobs", workflow.GetID()*10), http.StatusOK,
+			&github.Jobs{Jobs: []*github.WorkflowJob{{Name: workflow.Name}}})
+	}
+	h := newTestHandler(t, api)
+	h.rerunAllScope = rerunAllScopeRequired
+	h.matchMergeRef = true
+	h.rerunSuccessWithin = time.Hour
+
+	results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, pr, map[string]rerunOptions{testAll: {}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]string, len(results))
+	for _, result := range results {
+		got[result.workflowName] = result.selections
+	}
+	want := map[string][]string{
+		"build": {selectionHeadSHA, selectionRequiredCheck},
+		"lint":  {selectionMergeRefSHA, selectionRequiredCheck},
+		"docs":  {selectionHeadSHA, selectionRequiredCheck, selectionRecentSuccess},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got selections %v, want %v", got, want)
+	}
+	formatted := summary{results: results, selections: true}.formatResults()
+	if !strings.Contains(formatted, " Selected because |") ||
+		!strings.Contains(formatted, "| "+selectionMergeRefSHA+", "+selectionRequiredCheck+" |") {
+		t.Errorf("got summary %q, want selection reasons", formatted)
+	}
+}
+
+func TestRerunPRWorkflowsOrder(t *testing.T) {
+	workflow := func(id int64, name, path string) *github.Workflow {
+		w := testWorkflow(id, name)
+		w.Path = github.String(path)
+		return w
+	}
+	run := func(id, workflowID int64, created time.Duration) *github.WorkflowRun {
+		r := testRun(id, workflowID, testHeadSHA, failureConclusion)
+		r.CreatedAt = &github.Timestamp{Time: testPRCreatedAt.Add(created)}
+		return r
+	}
+	workflows := []*github.Workflow{workflow(3, "a", "c.yaml"), workflow(1, "c", "b.yaml"), workflow(2, "b", "a.yaml")}
+	runs := map[int64][]*github.WorkflowRun{
+		1: {run(10, 1, 3*time.Hour)},
+		2: {run(20, 2, time.Hour)},
+		3: {run(30, 3, 2*time.Hour)},
+	}
+	tests := []struct {
+		order string
+		want  []int64
+	}{
+		{order: rerunOrderName, want: []int64{30, 20, 10}},
+		{order: rerunOrderPath, want: []int64{20, 10, 30}},
+		{order: rerunOrderID, want: []int64{10, 20, 30}},
+		{order: rerunOrderCreated, want: []int64{20, 30, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows(workflows, runs)
+			api.handleReruns(10, 20, 30)
+			h := newTestHandler(t, api)
+			h.rerunOrder = tt.order
+
+			if _, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, true); err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for _, req := range api.requests {
+				var id int64
+				if _, err := fmt.Sscanf(req.URL.Path, "/repos/o/r/actions/runs/%d/rerun", &id); err == nil && req.Method == http.MethodPost {
+					got = append(got, id)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got reruns %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsLatestAttempt(t *testing.T) {
+	// Runs are listed newest first, each as of its latest attempt: run 12's second attempt succeeded,
+	// while older run 11 for the same head failed and must not be resurrected.
+	latest, older := testRun(12, 1, testHeadSHA, successfulConclusion), testRun(11, 1, testHeadSHA, failureConclusion)
+	latest.CreatedAt = &github.Timestamp{Time: testPRCreatedAt.Add(2 * time.Hour)}
+	api := newFakeAPI(t)
+	api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")}, map[int64][]*github.WorkflowRun{1: {latest, older}})
+	api.handleReruns(11, 12)
+	h := newTestHandler(t, api)
+
+	results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].run.GetID() != 12 || results[0].outcome != outcomeSkippedSucceeded {
+		t.Errorf("got results %+v, want only run 12 skipped as succeeded", results)
+	}
+	for _, id := range []int64{11, 12} {
+		if api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", id)) {
+			t.Errorf("run %d was rerun", id)
+		}
+	}
+}
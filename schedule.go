@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// defaultScheduleMaxPRs is the default number of PRs a scheduled run reruns workflows for.
+const defaultScheduleMaxPRs = 10
+
+// handleScheduled reruns failed required workflows on open PRs, least recently updated first,
+// for up to h.scheduleMaxPRs PRs. If h.scheduleLabel is set, only PRs with that label are considered.
+func (h *handler) handleScheduled(ctx context.Context, repoOwner, repoName string) error {
+	// Scheduled reruns should only retry checks that block merging, and never interrupt running workflows.
+	h.rerunAllScope = rerunAllScopeRequired
+	testsToRerun := map[string]rerunOptions{testAll: {skipIncomplete: true}}
+
+	opts := &github.PullRequestListOptions{
+		State:     "open",
+		Sort:      "updated",
+		Direction: "asc",
+	}
+	numPRs := 0
+	for {
+		prs, resp, err := h.PullRequests.List(ctx, repoOwner, repoName, opts)
+		if err != nil {
+			return fmt.Errorf("list PRs: %v", err)
+		}
+		for _, pr := range prs {
+			if h.scheduleLabel != "" && !hasLabel(pr.Labels, h.scheduleLabel) {
+				continue
+			}
+			if pr.GetLocked() {
+				h.Debugf("PR %d is locked", pr.GetNumber())
+				continue
+			}
+			results, err := h.rerunPRWorkflows(ctx, repoOwner, repoName, pr, testsToRerun, false)
+			if err == errNoActiveWorkflows {
+				if !h.ignoreNoWorkflows {
+					h.Warningf("No active workflows found, nothing to rerun")
+				}
+				return nil
+			}
+			if err != nil {
+				h.Errorf("Failed to rerun PR %d workflows: %v", pr.GetNumber(), err)
+				continue
+			}
+			for _, result := range results {
+				h.Debugf("PR %d workflow %s run %d: %s", pr.GetNumber(), result.workflowName, result.run.GetID(), result.outcome)
+			}
+			if numPRs++; numPRs == h.scheduleMaxPRs {
+				h.Debugf("Reached the maximum of %d PRs", h.scheduleMaxPRs)
+				return nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// hasLabel returns true if labels contains a label named name.
+func hasLabel(labels []*github.Label, name string) bool {
+	for _, label := range labels {
+		if label.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
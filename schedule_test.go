@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v33/github"
+)
+
+func TestHandleScheduled(t *testing.T) {
+	// PRs are listed least recently updated first; PR n's head is "sha<n>" and its build run is n*10.
+	tests := []struct {
+		number     int
+		labeled    bool
+		locked     bool
+		wantReran  bool
+		skipReason string
+	}{
+		{number: 1, labeled: true, wantReran: true},
+		{number: 2, skipReason: "unlabeled"},
+		{number: 3, labeled: true, locked: true, skipReason: "locked"},
+		{number: 4, labeled: true, wantReran: true},
+		{number: 5, labeled: true, skipReason: "past schedule_max_prs"},
+	}
+	var prs []*github.PullRequest
+	var runs []*github.WorkflowRun
+	api := newFakeAPI(t)
+	for _, tt := range tests {
+		pr := testPR()
+		pr.Number = github.Int(tt.number)
+		pr.Head.SHA = github.String(fmt.Sprintf("sha%d", tt.number))
+		pr.Locked = github.Bool(tt.locked)
+		if tt.labeled {
+			pr.Labels = []*github.Label{{Name: github.String("retest")}}
+		}
+		prs = append(prs, pr)
+		runID := int64(tt.number * 10)
+		runs = append(runs, testRun(runID, 1, pr.GetHead().GetSHA(), failureConclusion))
+		api.handle(http.MethodGet, fmt.Sprintf("/repos/o/r/actions/runs/%d/jobs", runID), http.StatusOK,
+			&github.Jobs{Jobs: []*github.WorkflowJob{{Name: github.String("build")}}})
+		api.handleReruns(runID)
+	}
+	api.handle(http.MethodGet, "/repos/o/r/pulls", http.StatusOK, prs)
+	api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")}, map[int64][]*github.WorkflowRun{1: runs})
+	api.handle(http.MethodGet, "/repos/o/r/branches/main/protection/required_status_checks", http.StatusOK,
+		&github.RequiredStatusChecks{Contexts: []string{"build"}})
+	h := newTestHandler(t, api)
+	h.scheduleLabel = "retest"
+	h.scheduleMaxPRs = 2
+
+	if err := h.handleScheduled(context.Background(), testOwner, testRepo); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		rerun := api.called(http.MethodPost, fmt.Sprintf("/repos/o/r/actions/runs/%d/rerun", tt.number*10))
+		if rerun != tt.wantReran {
+			t.Errorf("PR %d (%s): got rerun %t, want %t", tt.number, tt.skipReason, rerun, tt.wantReran)
+		}
+	}
+	query := api.requests[0].URL.Query()
+	if query.Get("state") != "open" || query.Get("sort") != "updated" || query.Get("direction") != "asc" {
+		t.Errorf("got PR list query %q, want open PRs least recently updated first", query.Encode())
+	}
+}
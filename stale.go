@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// dispatchStaleWorkflows dispatches each of workflows on pr's head branch whose most recent run on that branch
+// is stale, i.e. ran on a commit other than pr's head, ex. because the head's push did not match its path filters.
+// Rerunning a stale run would run the old commit again, so it is dispatched instead, see dispatchOnHead.
+func (h *handler) dispatchStaleWorkflows(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest,
+	workflows []*github.Workflow, commenterPrivileged bool) ([]rerunResult, error) {
+	var results []rerunResult
+	for _, workflow := range workflows {
+		if h.isSelfWorkflow(workflow) || workflow.GetState() != activeState || !h.isWorkflowPathAllowed(workflow.GetPath()) {
+			continue
+		}
+		run, err := h.findLatestBranchRun(ctx, repoOwner, repoName, workflow.GetID(), pr)
+		if err != nil {
+			return nil, fmt.Errorf("list workflow runs: %v", err)
+		}
+		if run == nil {
+			h.Debugf("Workflow %s has no runs on PR %d head branch", workflow.GetName(), pr.GetNumber())
+			continue
+		}
+		result := rerunResult{prNum: pr.GetNumber(), workflowName: workflow.GetName(), run: run}
+		if run.GetHeadSHA() == pr.GetHead().GetSHA() {
+			h.Debugf("Workflow %s run %d ran on PR %d head", workflow.GetName(), run.GetID(), pr.GetNumber())
+			result.outcome = outcomeSkippedOnHead
+		} else {
+			h.Debugf("Workflow %s run %d is stale, ran on %s", workflow.GetName(), run.GetID(), run.GetHeadSHA())
+			result.outcome = h.dispatchOnHead(ctx, repoOwner, repoName, pr, run, commenterPrivileged)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// findLatestBranchRun returns the most recent run of the workflow with workflowID for pr's head branch,
+// or nil if it has none. Runs of same-named branches of other repos are not considered.
+func (h *handler) findLatestBranchRun(ctx context.Context, repoOwner, repoName string, workflowID int64,
+	pr *github.PullRequest) (*github.WorkflowRun, error) {
+	opts := &github.ListWorkflowRunsOptions{
+		Branch:      pr.GetHead().GetRef(),
+		Event:       h.runEventQuery(),
+		ListOptions: github.ListOptions{PerPage: h.runsPerPage},
+	}
+	for {
+		workflowRuns, resp, err := h.Actions.ListWorkflowRunsByID(ctx, repoOwner, repoName, workflowID, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range workflowRuns.WorkflowRuns {
+			if !h.isRunEventMatched(run.GetEvent()) {
+				continue
+			}
+			if run.GetHeadRepository().GetFullName() != pr.GetHead().GetRepo().GetFullName() {
+				continue
+			}
+			return run, nil
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
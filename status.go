@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+)
+
+const (
+	// statusMarker identifies the consolidated status comment on a PR.
+	statusMarker = "<!-- rerun-actions-status -->"
+	// historyMarkerPrefix starts a hidden marker embedding a status comment's history as JSON.
+	historyMarkerPrefix = "<!-- rerun-actions-history "
+	// maxStatusHistory bounds the number of actions listed in the status comment.
+	maxStatusHistory = 20
+)
+
+// statusEntry records a command handled on a PR, for the status comment's history.
+type statusEntry struct {
+	Time     time.Time `json:"time"`
+	Login    string    `json:"login"`
+	Commands []string  `json:"commands"`
+	Reruns   int       `json:"reruns"`
+	// Dispatched counts workflows dispatched on the PR's head rather than rerun.
+	Dispatched int `json:"dispatched,omitempty"`
+}
+
+// newStatusEntry records commands by login that started results' runs again.
+func newStatusEntry(login string, commands []command, results []rerunResult) statusEntry {
+	entry := statusEntry{Time: time.Now().UTC(), Login: login}
+	for _, cmd := range commands {
+		entry.Commands = append(entry.Commands, "/"+cmd.Name)
+	}
+	for _, result := range results {
+		if result.started() {
+			entry.Reruns++
+		}
+		if result.dispatched() {
+			entry.Dispatched++
+		}
+	}
+	return entry
+}
+
+// parseHistoryMarker parses the history marker in body. Nil is returned if body has no valid marker.
+func parseHistoryMarker(body string) []statusEntry {
+	i := strings.Index(body, historyMarkerPrefix)
+	if i < 0 {
+		return nil
+	}
+	data := body[i+len(historyMarkerPrefix):]
+	if end := strings.Index(data, " -->"); end >= 0 {
+		data = data[:end]
+	}
+	var history []statusEntry
+	if err := json.Unmarshal([]byte(data), &history); err != nil {
+		return nil
+	}
+	return history
+}
+
+// formatStatus formats a status comment showing sum, the latest action's results, followed by history,
+// newest first. The comment is at most limit bytes; sum is truncated to fit.
+func formatStatus(sum summary, history []statusEntry, limit int) string {
+	sb := &strings.Builder{}
+	sb.WriteString("\n<details><summary>History</summary>\n\n")
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := history[i]
+		fmt.Fprintf(sb, "- %s: @%s ran %s, %d runs started",
+			entry.Time.Format(time.RFC3339), entry.Login, strings.Join(entry.Commands, ", "), entry.Reruns)
+		if entry.Dispatched != 0 {
+			fmt.Fprintf(sb, ", %d workflows dispatched", entry.Dispatched)
+		}
+		sb.WriteString("\n")
+	}
+	b, _ := json.Marshal(history)
+	fmt.Fprintf(sb, "\n</details>\n%s%s -->\n", historyMarkerPrefix, b)
+	trailer := sb.String()
+
+	header := statusMarker + "\n"
+	if len(history) != 0 {
+		header += fmt.Sprintf("Last updated %s.\n\n", history[len(history)-1].Time.Format(time.RFC3339))
+	}
+	return header + sum.comments(limit-len(header)-len(trailer), false)[0] + trailer
+}
+
+// updateStatusComment updates the status comment on the PR numbered prNum to show sum and add entry
+// to its history, creating the comment if the PR has none.
+func (h *handler) updateStatusComment(ctx context.Context, repoOwner, repoName string, prNum int,
+	sum summary, entry statusEntry) error {
+	comments, err := h.listBotComments(ctx, repoOwner, repoName, prNum, statusMarker)
+	if err != nil {
+		return err
+	}
+	var status *github.IssueComment
+	for _, comment := range comments {
+		if strings.HasPrefix(comment.GetBody(), statusMarker) {
+			status = comment
+			break
+		}
+	}
+
+	if status == nil {
+		body := formatStatus(sum, []statusEntry{entry}, maxCommentLength)
+		return h.createComment(ctx, repoOwner, repoName, prNum, body)
+	}
+	history := append(parseHistoryMarker(status.GetBody()), entry)
+	if len(history) > maxStatusHistory {
+		history = history[len(history)-maxStatusHistory:]
+	}
+	body := markBotComment(formatStatus(sum, history, maxCommentLength))
+	if _, _, err := h.Issues.EditComment(ctx, repoOwner, repoName, status.GetID(), &github.IssueComment{Body: &body}); err != nil {
+		return fmt.Errorf("edit comment: %v", err)
+	}
+	return nil
+}
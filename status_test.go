@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusHistoryRoundTrip(t *testing.T) {
+	history := []statusEntry{
+		{Time: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), Login: "alice", Commands: []string{"/rerun-all"}, Reruns: 2},
+		{Time: time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), Login: "bob", Commands: []string{"/rerun-workflow"}, Reruns: 1},
+	}
+	sum := summary{results: []rerunResult{{prNum: 1, workflowName: "build", outcome: outcomeRerun}}}
+	body := formatStatus(sum, history, maxCommentLength)
+	if !strings.HasPrefix(body, statusMarker) {
+		t.Errorf("got %q, want prefix %q", body, statusMarker)
+	}
+	if got := parseHistoryMarker(body); !reflect.DeepEqual(got, history) {
+		t.Errorf("got history %+v, want %+v", got, history)
+	}
+	if i, j := strings.Index(body, "@bob"), strings.Index(body, "@alice"); i < 0 || j < 0 || i > j {
+		t.Errorf("got %q, want history listed newest first", body)
+	}
+}
+
+func TestParseHistoryMarkerInvalid(t *testing.T) {
+	for _, body := range []string{"", statusMarker, historyMarkerPrefix + "not json -->"} {
+		if got := parseHistoryMarker(body); got != nil {
+			t.Errorf("parse %q: got %+v, want nil", body, got)
+		}
+	}
+}
+
+func TestFormatStatusLimit(t *testing.T) {
+	var results []rerunResult
+	for i := 0; i < 100; i++ {
+		results = append(results, rerunResult{prNum: 1, workflowName: strings.Repeat("w", 20), outcome: outcomeRerun})
+	}
+	history := []statusEntry{{Login: "alice", Commands: []string{"/rerun-all"}, Reruns: 100}}
+	const limit = 2000
+	body := formatStatus(summary{results: results}, history, limit)
+	if len(body) > limit {
+		t.Errorf("got %d bytes, want at most %d", len(body), limit)
+	}
+	if got := parseHistoryMarker(body); len(got) != 1 {
+		t.Errorf("got history %+v, want the truncated status to keep it", got)
+	}
+}
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	actions "github.com/sethvargo/go-githubactions"
+)
+
+// Outcomes of handling a matched workflow run.
+const (
+	outcomeRerun                = "rerun"
+	outcomeRerunFailed          = "rerun failed"
+	outcomeSkippedSucceeded     = "skipped, already succeeded"
+	outcomeSkippedIncomplete    = "skipped, not completed"
+	outcomeApproved             = "approved"
+	outcomeApproveFailed        = "approval failed"
+	outcomeAwaitingApproval     = "awaiting approval by a maintainer"
+	outcomeSkippedNotRerunnable = "skipped, not rerunnable yet"
+	outcomePermissionDenied     = "rerun failed, token lacks permission"
+	outcomeSkippedConclusion    = "skipped, conclusion not selected"
+	// Outcomes of dispatching workflows whose runs were skipped or stale.
+	outcomeDispatched               = "dispatched"
+	outcomeDispatchFailed           = "dispatch failed"
+	outcomeSkippedNoDispatch        = "skipped, commenter cannot dispatch"
+	outcomeSkippedFork              = "skipped, cannot dispatch on a fork"
+	outcomeSkippedNoDispatchTrigger = "skipped, no workflow_dispatch trigger"
+	outcomeSkippedOnHead            = "skipped, already ran on head"
+	// outcomeSkippedCooldown is formatted with the time the workflow's cooldown ends.
+	outcomeSkippedCooldown = "skipped, on cooldown until %s"
+)
+
+// Reasons a workflow run was selected for a command.
+const (
+	selectionHeadSHA       = "matched head SHA"
+	selectionMergeRefSHA   = "matched merge ref SHA"
+	selectionBaseHead      = "base branch head"
+	selectionRequiredCheck = "reports a required check"
+	selectionRecentSuccess = "succeeded within rerun_success_within"
+)
+
+// rerunResult records what was done with a matched workflow run.
+type rerunResult struct {
+	prNum        int
+	workflowName string
+	run          *github.WorkflowRun
+	outcome      string
+	// conclusion is the conclusion of a rerun or approved run, set once the run completes.
+	conclusion string
+	// logSnippet, if set, is an excerpt of the run's logs from before it was rerun.
+	logSnippet string
+	// selections are why the run was selected, ex. selectionHeadSHA.
+	selections []string
+	// err describes why an API call made for the run failed, if one did.
+	err string
+	// failedJobURL, if set, links to the first failed job of a rerun that did not succeed.
+	failedJobURL string
+	// cooldownName, if set, is the name of the workflow cooldowns are recorded for, when workflowName is decorated
+	// for display, ex. with a base branch.
+	cooldownName string
+}
+
+// cooldownWorkflow returns the name of the workflow r's rerun is recorded for in workflow_cooldowns.
+func (r rerunResult) cooldownWorkflow() string {
+	if r.cooldownName != "" {
+		return r.cooldownName
+	}
+	return r.workflowName
+}
+
+// result describes r for the summary.
+func (r rerunResult) result() string {
+	if r.err != "" {
+		return fmt.Sprintf("%s (%s)", r.outcome, r.err)
+	}
+	if r.failedJobURL != "" {
+		return fmt.Sprintf("%s, %s ([failed job](%s))", r.outcome, r.conclusion, r.failedJobURL)
+	}
+	if r.conclusion != "" {
+		return r.outcome + ", " + r.conclusion
+	}
+	return r.outcome
+}
+
+// failed returns true if an API call made for r's run failed.
+func (r rerunResult) failed() bool {
+	return r.outcome == outcomeRerunFailed || r.outcome == outcomeApproveFailed || r.outcome == outcomePermissionDenied ||
+		r.outcome == outcomeDispatchFailed
+}
+
+// started returns true if r's run was started again by a rerun or approval.
+func (r rerunResult) started() bool {
+	return r.outcome == outcomeRerun || r.outcome == outcomeApproved
+}
+
+// dispatched returns true if r's workflow was dispatched to run on the PR's head. The new run is not r's run,
+// so it is not started, and is not waited for.
+func (r rerunResult) dispatched() bool {
+	return r.outcome == outcomeDispatched
+}
+
+// maxErrorLength bounds the length of an error described in the summary.
+const maxErrorLength = 200
+
+// describeError describes err briefly for the summary, ex. "403: Resource not accessible by integration".
+func describeError(err error) string {
+	desc := err.Error()
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		desc = fmt.Sprintf("%d: %s", errResp.Response.StatusCode, errResp.Message)
+	}
+	// Errors end up in a table cell, which cannot contain newlines or unescaped pipes.
+	desc = strings.NewReplacer("\n", " ", "|", "\\|").Replace(desc)
+	if len(desc) > maxErrorLength {
+		desc = desc[:maxErrorLength] + "..."
+	}
+	return desc
+}
+
+// resultOutput is an element of the results output.
+type resultOutput struct {
+	PR       int    `json:"pr"`
+	Workflow string `json:"workflow"`
+	RunID    int64  `json:"run_id"`
+	Outcome  string `json:"outcome"`
+	// Conclusion is set for started runs that were waited for.
+	Conclusion string `json:"conclusion,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// setResultsOutput sets the results output to describe each of results, so later steps can tell which
+// reruns succeeded or failed.
+func (h *handler) setResultsOutput(results []rerunResult) {
+	outputs := make([]resultOutput, 0, len(results))
+	for _, result := range results {
+		outputs = append(outputs, resultOutput{
+			PR:         result.prNum,
+			Workflow:   result.workflowName,
+			RunID:      result.run.GetID(),
+			Outcome:    result.outcome,
+			Conclusion: result.conclusion,
+			Error:      result.err,
+		})
+	}
+	b, err := json.Marshal(outputs)
+	if err != nil {
+		h.Errorf("Failed to encode results output: %v", err)
+		return
+	}
+	h.SetOutput("results", string(b))
+}
+
+// noticeSummary summarizes results of a command on the PR numbered prNum in a line, ex. "Reran 3 workflows for PR #42".
+func noticeSummary(prNum int, results []rerunResult) string {
+	var started, dispatched, failed int
+	for _, result := range results {
+		switch {
+		case result.started():
+			started++
+		case result.dispatched():
+			dispatched++
+		case result.failed():
+			failed++
+		}
+	}
+	notice := fmt.Sprintf("Reran %d workflows", started)
+	if dispatched != 0 {
+		notice += fmt.Sprintf(" and dispatched %d", dispatched)
+	}
+	notice += fmt.Sprintf(" for PR #%d", prNum)
+	if skipped := len(results) - started - dispatched - failed; skipped != 0 {
+		notice += fmt.Sprintf(", %d skipped", skipped)
+	}
+	if failed != 0 {
+		notice += fmt.Sprintf(", %d failed", failed)
+	}
+	return notice
+}
+
+// notice emits a notice annotation with msg, which is shown in the run's summary alongside warnings and errors.
+func (h *handler) notice(msg string) {
+	// go-githubactions has no Noticef, but escapes any command's message.
+	h.IssueCommand(&actions.Command{Name: "notice", Message: msg})
+}
+
+// hasOutcome returns true if any of results has outcome.
+func hasOutcome(results []rerunResult, outcome string) bool {
+	for _, result := range results {
+		if result.outcome == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// anyFailed returns true if any of results failed.
+func anyFailed(results []rerunResult) bool {
+	for _, result := range results {
+		if result.failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// summary describes what was done for a command, for commenting on the PR.
+type summary struct {
+	results []rerunResult
+	// triggeredBy, if set, is the login reruns are attributed to.
+	triggeredBy string
+	// reasons are the reasons given for the commands, ex. "#flaky".
+	reasons []string
+	// stats, if set, are cumulative rerun counts per commenter.
+	stats rerunStats
+	// selections adds why each run was selected to the results table.
+	selections bool
+	// compact formats results as a single line instead of a table.
+	compact bool
+	// rerunTimes, if set, are the times workflows with cooldowns were last rerun.
+	rerunTimes rerunTimes
+}
+
+// comments formats s as one or more markdown PR comments of at most limit bytes each.
+// If chunk is false, a summary that is too long is truncated to one comment.
+func (s summary) comments(limit int, chunk bool) []string {
+	if s.compact {
+		body := s.formatCompact()
+		if s.stats != nil {
+			body += s.stats.marker() + "\n"
+		}
+		if s.rerunTimes != nil {
+			body += s.rerunTimes.marker() + "\n"
+		}
+		return []string{body + summaryMarker + "\n"}
+	}
+	table, trailer := s.formatResults(), s.formatTrailer()
+	if len(table)+len(trailer) <= limit {
+		return []string{table + trailer}
+	}
+	if chunk {
+		return chunkLines(table+trailer, limit)
+	}
+	return []string{truncateLines(table, limit-len(trailer)) + trailer}
+}
+
+// formatResults formats s's results as markdown.
+func (s summary) formatResults() string {
+	results := s.results
+	sb := &strings.Builder{}
+	if len(results) == 0 {
+		sb.WriteString("No workflow runs matching this PR's head commit were found.\n")
+	} else {
+		// Results of a PR stack need to say which PR a run belongs to.
+		withPRs := spansPRs(results)
+		header, divider := "| Workflow | Run | Result |", "| --- | --- | --- |"
+		if withPRs {
+			header, divider = "| PR "+header, "| --- "+divider
+		}
+		if s.selections {
+			header, divider = header+" Selected because |", divider+" --- |"
+		}
+		fmt.Fprintf(sb, "%s\n%s\n", header, divider)
+		for _, result := range results {
+			if withPRs {
+				fmt.Fprintf(sb, "| #%d ", result.prNum)
+			}
+			fmt.Fprintf(sb, "| %s | [%d](%s) | %s |",
+				result.workflowName, result.run.GetID(), result.run.GetHTMLURL(), result.result())
+			if s.selections {
+				fmt.Fprintf(sb, " %s |", strings.Join(result.selections, ", "))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	for _, result := range results {
+		if result.logSnippet != "" {
+			fence := codeFence(result.logSnippet)
+			fmt.Fprintf(sb, "\n<details><summary>%s run %d logs</summary>\n\n%stext\n%s\n%s\n</details>\n",
+				result.workflowName, result.run.GetID(), fence, result.logSnippet, fence)
+		}
+	}
+	return sb.String()
+}
+
+// codeFence returns a markdown code fence longer than any run of backticks in code, so code cannot close it early.
+func codeFence(code string) string {
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+// formatCompact formats s as a single line, ex. "✅ Reran CI, Lint (2 runs) — @login".
+func (s summary) formatCompact() string {
+	var names []string
+	seen := make(map[string]struct{})
+	started, dispatched := 0, 0
+	for _, result := range s.results {
+		if result.dispatched() {
+			dispatched++
+		}
+		if !result.started() {
+			continue
+		}
+		started++
+		if _, isSeen := seen[result.workflowName]; !isSeen {
+			seen[result.workflowName] = struct{}{}
+			names = append(names, result.workflowName)
+		}
+	}
+	icon := "✅"
+	if anyFailed(s.results) {
+		icon = "⚠️"
+	}
+	line := icon + " No workflow runs were rerun"
+	if started != 0 {
+		line = fmt.Sprintf("%s Reran %s (%d runs)", icon, strings.Join(names, ", "), started)
+	}
+	if dispatched != 0 {
+		line += fmt.Sprintf(", dispatched %d workflows", dispatched)
+	}
+	if s.triggeredBy != "" {
+		line += " — @" + s.triggeredBy
+	}
+	return line + "\n"
+}
+
+// formatTrailer formats the parts of s following its results as markdown.
+func (s summary) formatTrailer() string {
+	sb := &strings.Builder{}
+	if len(s.reasons) != 0 {
+		fmt.Fprintf(sb, "\nReason: %s\n", strings.Join(s.reasons, ", "))
+	}
+	if s.triggeredBy != "" {
+		fmt.Fprintf(sb, "\nTriggered by @%s. To stop a rerun, cancel it from its linked run page.\n", s.triggeredBy)
+	}
+	if s.stats != nil {
+		fmt.Fprintf(sb, "\nReruns on this PR by user: %s\n%s\n", s.stats, s.stats.marker())
+	}
+	if s.rerunTimes != nil {
+		fmt.Fprintf(sb, "%s\n", s.rerunTimes.marker())
+	}
+	sb.WriteString(summaryMarker + "\n")
+	return sb.String()
+}
+
+// chunkLines splits body into chunks of at most limit bytes without splitting lines or log snippets, unless one
+// alone exceeds limit. A markdown table split across chunks has its header repeated in each chunk.
+func chunkLines(body string, limit int) (chunks []string) {
+	lines := markdownBlocks(body)
+	sb := &strings.Builder{}
+	header := ""
+	for i, line := range lines {
+		switch {
+		case i+1 < len(lines) && strings.HasPrefix(lines[i+1], "| ---"):
+			header = line + lines[i+1]
+		case !strings.HasPrefix(line, "|"):
+			header = ""
+		}
+		if sb.Len() > 0 && sb.Len()+len(line) > limit {
+			chunks = append(chunks, sb.String())
+			sb.Reset()
+			if header != "" && !strings.HasPrefix(header, line) && !strings.HasPrefix(line, "| ---") {
+				sb.WriteString(header)
+			}
+		}
+		sb.WriteString(line)
+	}
+	if sb.Len() > 0 {
+		chunks = append(chunks, sb.String())
+	}
+	return chunks
+}
+
+// truncateLines truncates body to at most limit bytes without splitting lines or log snippets,
+// ending it with a note of how many lines were omitted.
+func truncateLines(body string, limit int) string {
+	if len(body) <= limit {
+		return body
+	}
+	body = strings.TrimSuffix(body, "\n")
+	numLines := strings.Count(body, "\n") + 1
+	// Leave room for the note.
+	limit -= len(fmt.Sprintf(truncatedNote, numLines))
+	sb := &strings.Builder{}
+	kept := 0
+	for _, block := range markdownBlocks(body) {
+		if sb.Len()+len(block) > limit {
+			fmt.Fprintf(sb, truncatedNote, numLines-kept)
+			break
+		}
+		sb.WriteString(block)
+		kept += strings.Count(block, "\n")
+	}
+	return sb.String()
+}
+
+// markdownBlocks splits body after each line, except within a <details> block, ex. a log snippet,
+// which is kept whole so that its code fence and the block itself are never left unclosed.
+func markdownBlocks(body string) (blocks []string) {
+	lines := strings.SplitAfter(body, "\n")
+	for i := 0; i < len(lines); i++ {
+		block := lines[i]
+		if strings.HasPrefix(block, "<details>") {
+			for !strings.HasPrefix(lines[i], "</details>") && i+1 < len(lines) {
+				i++
+				block += lines[i]
+			}
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// truncatedNote follows a truncated summary. The leading blank line ends any table.
+const truncatedNote = "\n…%d more lines omitted.\n"
+
+// spansPRs returns true if results belong to more than one PR.
+func spansPRs(results []rerunResult) bool {
+	for _, result := range results {
+		if result.prNum != results[0].prNum {
+			return true
+		}
+	}
+	return false
+}
+
+// botCommentMarker marks comments posted by this action, whose commands, ex. quoted in a summary,
+// are never run, even if the comment is quoted by a user.
+const botCommentMarker = "<!-- rerun-actions-comment -->"
+
+// markBotComment adds botCommentMarker to body, unless the marker would make body too long to post.
+func markBotComment(body string) string {
+	if strings.Contains(body, botCommentMarker) || len(body)+len(botCommentMarker)+1 > maxCommentLength {
+		return body
+	}
+	return body + "\n" + botCommentMarker
+}
+
+// summaryMarker marks summaries of commands this action acted on, as opposed to its other replies, ex. rejections.
+const summaryMarker = "<!-- rerun-actions-summary -->"
+
+// getLastActionTime returns when this action last summarized commands it acted on in the PR numbered prNum,
+// or the zero time if it has not. Other replies, ex. rejecting a command, are not actions, so they do not count.
+// The consolidated status comment is edited rather than recreated, so comments' update times are used.
+func (h *handler) getLastActionTime(ctx context.Context, repoOwner, repoName string, prNum int) (time.Time, error) {
+	comments, err := h.listBotComments(ctx, repoOwner, repoName, prNum, summaryMarker)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var last time.Time
+	for _, comment := range comments {
+		if updated := comment.GetUpdatedAt(); updated.After(last) {
+			last = updated
+		}
+	}
+	return last, nil
+}
+
+// statsMarkerPrefix starts a hidden marker embedding rerunStats as JSON in a summary comment.
+const statsMarkerPrefix = "<!-- rerun-actions-stats "
+
+// rerunStats counts the reruns each commenter has triggered on a PR.
+type rerunStats map[string]int
+
+// parseStatsMarker parses the stats marker in body. A nil rerunStats is returned if body has no valid marker.
+func parseStatsMarker(body string) rerunStats {
+	i := strings.Index(body, statsMarkerPrefix)
+	if i < 0 {
+		return nil
+	}
+	data := body[i+len(statsMarkerPrefix):]
+	if end := strings.Index(data, " -->"); end >= 0 {
+		data = data[:end]
+	}
+	stats := rerunStats{}
+	if err := json.Unmarshal([]byte(data), &stats); err != nil {
+		return nil
+	}
+	return stats
+}
+
+// marker returns a hidden marker recording stats.
+func (stats rerunStats) marker() string {
+	b, _ := json.Marshal(stats)
+	return statsMarkerPrefix + string(b) + " -->"
+}
+
+// String lists commenters by descending rerun count, ex. "@alice (3), @bob (1)".
+func (stats rerunStats) String() string {
+	logins := make([]string, 0, len(stats))
+	for login := range stats {
+		logins = append(logins, login)
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		if stats[logins[i]] != stats[logins[j]] {
+			return stats[logins[i]] > stats[logins[j]]
+		}
+		return logins[i] < logins[j]
+	})
+	entries := make([]string, len(logins))
+	for i, login := range logins {
+		entries[i] = fmt.Sprintf("@%s (%d)", login, stats[login])
+	}
+	return strings.Join(entries, ", ")
+}
+
+// getRerunStats returns the stats recorded in the latest bot summary on the PR numbered prNum.
+// Empty stats are returned if there is no such summary.
+func (h *handler) getRerunStats(ctx context.Context, repoOwner, repoName string, prNum int) (rerunStats, error) {
+	comments, err := h.listBotComments(ctx, repoOwner, repoName, prNum, statsMarkerPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, comment := range comments {
+		if stats := parseStatsMarker(comment.GetBody()); stats != nil {
+			return stats, nil
+		}
+	}
+	return rerunStats{}, nil
+}
+
+// listBotComments returns comments by h.botLogin on the PR numbered prNum containing marker, newest first.
+// Comments by other users or bots are never matched, even if they copy marker.
+// Only the h.commentScanDepth most recent comments updated within h.commentScanMaxAge are scanned, if set.
+func (h *handler) listBotComments(ctx context.Context, repoOwner, repoName string, prNum int,
+	marker string) ([]*github.IssueComment, error) {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: maxRunsPerPage}}
+	if h.commentScanMaxAge > 0 {
+		since := time.Now().Add(-h.commentScanMaxAge)
+		opts.Since = &since
+	}
+	first, resp, err := h.Issues.ListComments(ctx, repoOwner, repoName, prNum, opts)
+	if err != nil {
+		return nil, fmt.Errorf("list comments: %v", err)
+	}
+
+	// Comments are listed oldest first, so scan pages from the last one back.
+	page := 1
+	if resp.LastPage > 1 {
+		page = resp.LastPage
+	}
+	var matched []*github.IssueComment
+	scanned := 0
+	for {
+		comments := first
+		if page != 1 {
+			opts.Page = page
+			if comments, _, err = h.Issues.ListComments(ctx, repoOwner, repoName, prNum, opts); err != nil {
+				return nil, fmt.Errorf("list comments: %v", err)
+			}
+		}
+		for i := len(comments) - 1; i >= 0; i-- {
+			if h.commentScanDepth > 0 && scanned == h.commentScanDepth {
+				return matched, nil
+			}
+			scanned++
+			if comment := comments[i]; comment.GetUser().GetLogin() == h.botLogin && strings.Contains(comment.GetBody(), marker) {
+				matched = append(matched, comment)
+			}
+		}
+		if page == 1 {
+			return matched, nil
+		}
+		page--
+	}
+}
+
+const (
+	// summaryCheckRunName is the name of the check run recording a summary.
+	summaryCheckRunName = "rerun-actions"
+	// maxCheckRunTextLength is the maximum length of a check run's output text, one less than a comment's.
+	maxCheckRunTextLength = 65535
+)
+
+// createSummaryCheckRun records sum as a completed check run on headSHA, with the full summary as its details
+// and a one-line summary as its title. The check run is neutral if any API calls failed, so it does not
+// fail the PR's checks.
+func (h *handler) createSummaryCheckRun(ctx context.Context, repoOwner, repoName, headSHA string, sum summary) error {
+	sum.compact = true
+	title := strings.TrimSpace(sum.formatCompact())
+	sum.compact = false
+	text := sum.comments(maxCheckRunTextLength, false)[0]
+	conclusion := successfulConclusion
+	if anyFailed(sum.results) {
+		conclusion = "neutral"
+	}
+	now := github.Timestamp{Time: time.Now()}
+	_, _, err := h.Checks.CreateCheckRun(ctx, repoOwner, repoName, github.CreateCheckRunOptions{
+		Name:        summaryCheckRunName,
+		HeadSHA:     headSHA,
+		Status:      github.String(completedStatus),
+		Conclusion:  &conclusion,
+		CompletedAt: &now,
+		Output: &github.CheckRunOutput{
+			Title:   &title,
+			Summary: &title,
+			Text:    &text,
+		},
+	})
+	return err
+}
+
+// createComment comments body on the issue or PR numbered issueNum.
+func (h *handler) createComment(ctx context.Context, repoOwner, repoName string, issueNum int, body string) error {
+	body = markBotComment(body)
+	_, _, err := h.Issues.CreateComment(ctx, repoOwner, repoName, issueNum, &github.IssueComment{Body: &body})
+	return err
+}
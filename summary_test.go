@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+	actions "github.com/sethvargo/go-githubactions"
+)
+
+func TestStatsMarker(t *testing.T) {
+	stats := rerunStats{"alice": 3, "bob": 1}
+	body := "| Workflow | Run | Result |\n" + stats.marker() + "\n"
+	if got := parseStatsMarker(body); !reflect.DeepEqual(got, stats) {
+		t.Errorf("got %v, want %v", got, stats)
+	}
+	for _, body := range []string{"", "no marker", statsMarkerPrefix + "not json -->"} {
+		if got := parseStatsMarker(body); got != nil {
+			t.Errorf("parse %q: got %v, want nil", body, got)
+		}
+	}
+}
+
+func TestRerunStatsString(t *testing.T) {
+	tests := []struct {
+		stats rerunStats
+		want  string
+	}{
+		{stats: rerunStats{}, want: ""},
+		{stats: rerunStats{"alice": 1}, want: "@alice (1)"},
+		{stats: rerunStats{"bob": 1, "alice": 3, "carol": 1}, want: "@alice (3), @bob (1), @carol (1)"},
+	}
+	for _, tt := range tests {
+		if got := tt.stats.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestChunkLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		limit int
+		want  []string
+	}{
+		{name: "fits", body: "a\nb\n", limit: 10, want: []string{"a\nb\n"}},
+		{name: "split at lines", body: "a\nb\nc\n", limit: 4, want: []string{"a\nb\n", "c\n"}},
+		{name: "long line", body: "aaaaaa\nb\n", limit: 4, want: []string{"aaaaaa\n", "b\n"}},
+		{
+			name:  "table header repeated",
+			body:  "| h |\n| --- |\n| 1 |\n| 2 |\n",
+			limit: 22,
+			want:  []string{"| h |\n| --- |\n| 1 |\n", "| h |\n| --- |\n| 2 |\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunkLines(tt.body, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateLines(t *testing.T) {
+	if got := truncateLines("a\nb\n", 10); got != "a\nb\n" {
+		t.Errorf("got %q, want body unchanged", got)
+	}
+
+	sb := &strings.Builder{}
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(sb, "line %d\n", i)
+	}
+	const limit = 50
+	got := truncateLines(sb.String(), limit)
+	if len(got) > limit {
+		t.Errorf("got %d bytes, want at most %d", len(got), limit)
+	}
+	kept := strings.Count(got, "line ")
+	if want := "line 0\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want prefix %q", got, want)
+	}
+	if want := fmt.Sprintf(truncatedNote, 10-kept); !strings.HasSuffix(got, want) {
+		t.Errorf("got %q, want suffix %q", got, want)
+	}
+}
+
+func TestMarkdownBlocks(t *testing.T) {
+	body := "| a |\n\n<details><summary>logs</summary>\n\n```text\nerror\n```\n</details>\nb\n"
+	want := []string{"| a |\n", "\n", "<details><summary>logs</summary>\n\n```text\nerror\n```\n</details>\n", "b\n", ""}
+	if got := markdownBlocks(body); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// isMarkdownClosed returns true if body has no unclosed code fence or details block.
+func isMarkdownClosed(body string) bool {
+	return strings.Count(body, "```")%2 == 0 && strings.Count(body, "<details>") == strings.Count(body, "</details>")
+}
+
+func TestSummaryCommentsLogSnippets(t *testing.T) {
+	var results []rerunResult
+	for i := 0; i < 20; i++ {
+		results = append(results, rerunResult{prNum: 1, workflowName: fmt.Sprintf("workflow %d", i), outcome: outcomeRerun,
+			run: &github.WorkflowRun{ID: github.Int64(int64(i))}, logSnippet: strings.Repeat("##[error]failed\n", 10)})
+	}
+	sum := summary{results: results}
+	const limit = 2000
+	truncated := sum.comments(limit, false)
+	if len(truncated) != 1 || len(truncated[0]) > limit {
+		t.Fatalf("got %d comments, first of %d bytes, want 1 of at most %d bytes", len(truncated), len(truncated[0]), limit)
+	}
+	if !isMarkdownClosed(truncated[0]) || !strings.Contains(truncated[0], "more lines omitted") {
+		t.Errorf("got truncated summary %q, want closed snippets followed by a note", truncated[0])
+	}
+	chunks := sum.comments(limit, true)
+	for i, chunk := range chunks {
+		if len(chunk) > limit || !isMarkdownClosed(chunk) {
+			t.Errorf("chunk %d: got %q, want at most %d bytes of closed snippets", i, chunk, limit)
+		}
+	}
+}
+
+func TestCodeFence(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{code: "error", want: "```"},
+		{code: "run `make`", want: "```"},
+		{code: "```\nerror", want: "````"},
+		{code: "````go", want: "`````"},
+	}
+	for _, tt := range tests {
+		if got := codeFence(tt.code); got != tt.want {
+			t.Errorf("codeFence(%q): got %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestSummaryCommentsLimit(t *testing.T) {
+	var results []rerunResult
+	for i := 0; i < 100; i++ {
+		results = append(results, rerunResult{prNum: 1, workflowName: fmt.Sprintf("workflow %d", i), outcome: outcomeRerun})
+	}
+	sum := summary{results: results, triggeredBy: "alice"}
+	const limit = 1000
+	truncated := sum.comments(limit, false)
+	if len(truncated) != 1 || len(truncated[0]) > limit {
+		t.Errorf("got %d comments, first of %d bytes, want 1 of at most %d bytes", len(truncated), len(truncated[0]), limit)
+	}
+	chunks := sum.comments(limit, true)
+	if len(chunks) < 2 {
+		t.Errorf("got %d chunks, want several", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > limit {
+			t.Errorf("chunk %d: got %d bytes, want at most %d", i, len(chunk), limit)
+		}
+	}
+	if !strings.Contains(chunks[len(chunks)-1], "@alice") {
+		t.Errorf("last chunk %q does not end with the trailer", chunks[len(chunks)-1])
+	}
+}
+
+// testBotComment returns a comment with body by the user with login and type.
+func testBotComment(id int64, login, userType, body string) *github.IssueComment {
+	return &github.IssueComment{
+		ID:   github.Int64(id),
+		Body: github.String(body),
+		User: &github.User{Login: github.String(login), Type: github.String(userType)},
+	}
+}
+
+func TestListBotComments(t *testing.T) {
+	marker := rerunStats{"alice": 1}.marker()
+	comments := []*github.IssueComment{
+		testBotComment(1, "machine-user", "User", marker),
+		testBotComment(2, "other-app[bot]", "Bot", marker),
+		testBotComment(3, "alice", "User", "quoting "+marker),
+		testBotComment(4, "machine-user", "User", "no marker"),
+		testBotComment(5, "machine-user", "User", marker),
+	}
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/issues/1/comments", http.StatusOK, comments)
+	h := newTestHandler(t, api)
+	h.botLogin = "machine-user"
+
+	got, err := h.listBotComments(context.Background(), testOwner, testRepo, testPRNum, statsMarkerPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, comment := range got {
+		ids = append(ids, comment.GetID())
+	}
+	if want := []int64{5, 1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got comments %v, want %v", ids, want)
+	}
+}
+
+func TestCreateSummaryCheckRunLimit(t *testing.T) {
+	var results []rerunResult
+	for i := 0; i < 2000; i++ {
+		results = append(results, rerunResult{prNum: 1, workflowName: strings.Repeat("w", 50), outcome: outcomeRerun})
+	}
+	api := newFakeAPI(t)
+	api.handle(http.MethodPost, "/repos/o/r/check-runs", http.StatusCreated, &github.CheckRun{})
+	h := newTestHandler(t, api)
+
+	if err := h.createSummaryCheckRun(context.Background(), testOwner, testRepo, testHeadSHA, summary{results: results}); err != nil {
+		t.Fatal(err)
+	}
+	var opts github.CreateCheckRunOptions
+	api.body(t, http.MethodPost, "/repos/o/r/check-runs", &opts)
+	if text := opts.GetOutput().GetText(); len(text) > maxCheckRunTextLength {
+		t.Errorf("got text of %d bytes, want at most %d", len(text), maxCheckRunTextLength)
+	}
+}
+
+func TestMarkBotComment(t *testing.T) {
+	body := markBotComment("Reran build")
+	if !strings.Contains(body, botCommentMarker) {
+		t.Errorf("got %q, want marker", body)
+	}
+	if again := markBotComment(body); again != body {
+		t.Errorf("got %q, want marked body unchanged", again)
+	}
+	long := strings.Repeat("a", maxCommentLength-len(botCommentMarker))
+	if got := markBotComment(long); got != long {
+		t.Errorf("got %d bytes, want a body too long to mark unchanged", len(got))
+	}
+	if commands := (commandParser{}).parseCommands(markBotComment("/rerun-all")); commands != nil {
+		t.Errorf("got commands %+v from a marked comment, want none", commands)
+	}
+}
+
+func TestGetLastActionTime(t *testing.T) {
+	summarized := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	summaryComment := testBotComment(1, defaultBotLogin, "Bot", summary{}.comments(maxCommentLength, false)[0])
+	summaryComment.UpdatedAt = &summarized
+	rejected := summarized.Add(time.Hour)
+	rejectionComment := testBotComment(2, defaultBotLogin, "Bot", markBotComment("The following commands were not run"))
+	rejectionComment.UpdatedAt = &rejected
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/issues/1/comments", http.StatusOK,
+		[]*github.IssueComment{summaryComment, rejectionComment})
+	h := newTestHandler(t, api)
+
+	got, err := h.getLastActionTime(context.Background(), testOwner, testRepo, testPRNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(summarized) {
+		t.Errorf("got %s, want the summary's time %s", got, summarized)
+	}
+}
+
+func TestSummaryCommentsMarked(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		sum := summary{results: []rerunResult{{prNum: 1, workflowName: "build", outcome: outcomeRerun}}, compact: compact}
+		if body := sum.comments(maxCommentLength, false)[0]; !strings.Contains(body, summaryMarker) {
+			t.Errorf("compact %t: got %q, want summary marker", compact, body)
+		}
+	}
+}
+
+func TestNoticeSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []rerunResult
+		want    string
+	}{
+		{name: "none", want: "Reran 0 workflows for PR #1"},
+		{
+			name:    "started and skipped",
+			results: []rerunResult{{outcome: outcomeRerun}, {outcome: outcomeApproved}, {outcome: outcomeSkippedSucceeded}},
+			want:    "Reran 2 workflows for PR #1, 1 skipped",
+		},
+		{
+			name:    "dispatched",
+			results: []rerunResult{{outcome: outcomeDispatched}, {outcome: outcomeDispatched}},
+			want:    "Reran 0 workflows and dispatched 2 for PR #1",
+		},
+		{
+			name:    "failed",
+			results: []rerunResult{{outcome: outcomeRerun}, {outcome: outcomeRerunFailed}, {outcome: outcomeDispatchFailed}},
+			want:    "Reran 1 workflows for PR #1, 2 failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := noticeSummary(1, tt.results); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotice(t *testing.T) {
+	out := &bytes.Buffer{}
+	h := &handler{Action: actions.NewWithWriter(out)}
+	h.notice("100%\ndone")
+	if got, want := out.String(), "::notice::100%25%0Adone\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatCompactDispatched(t *testing.T) {
+	sum := summary{results: []rerunResult{
+		{workflowName: "build", outcome: outcomeRerun},
+		{workflowName: "lint", outcome: outcomeDispatched},
+	}}
+	if got, want := sum.formatCompact(), "✅ Reran build (1 runs), dispatched 1 workflows\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
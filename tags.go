@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// defaultWorkflowTagKey starts a comment in a workflow file tagging the workflow, ex. "# rerun-tag: smoke, e2e".
+const defaultWorkflowTagKey = "rerun-tag"
+
+// expandWorkflowTags returns a copy of testsToRerun with each tag replaced by the names of allWorkflows whose
+// files carry the tag. Options of a workflow selected otherwise take precedence.
+func (h *handler) expandWorkflowTags(ctx context.Context, repoOwner, repoName string,
+	testsToRerun map[string]rerunOptions, allWorkflows []*github.Workflow) (map[string]rerunOptions, error) {
+	expanded := make(map[string]rerunOptions, len(testsToRerun))
+	tagOpts := make(map[string]rerunOptions)
+	for name, opts := range testsToRerun {
+		if strings.HasPrefix(name, testTagPrefix) {
+			tagOpts[strings.TrimPrefix(name, testTagPrefix)] = opts
+		} else {
+			expanded[name] = opts
+		}
+	}
+	if len(tagOpts) == 0 {
+		return expanded, nil
+	}
+
+	// Workflows with several selected tags are rerun as the least restrictive tag asks.
+	tagged := make(map[string]struct{})
+	taggedAny := make(map[string]bool, len(tagOpts))
+	for _, workflow := range allWorkflows {
+		tags, err := h.getWorkflowTags(ctx, repoOwner, repoName, workflow.GetPath())
+		if err != nil {
+			return nil, fmt.Errorf("get workflow %s tags: %v", workflow.GetName(), err)
+		}
+		for _, tag := range tags {
+			opts, selected := tagOpts[tag]
+			if !selected {
+				continue
+			}
+			taggedAny[tag] = true
+			if prevOpts, hasWorkflow := expanded[workflow.GetName()]; hasWorkflow {
+				if _, isTagged := tagged[workflow.GetName()]; !isTagged {
+					continue
+				}
+				opts = mergeOptions(prevOpts, opts)
+			}
+			h.Debugf("Workflow %s is tagged %s", workflow.GetName(), tag)
+			expanded[workflow.GetName()] = opts
+			tagged[workflow.GetName()] = struct{}{}
+		}
+	}
+	for tag := range tagOpts {
+		if !taggedAny[tag] {
+			h.Warningf("No workflow tagged %q found", tag)
+		}
+	}
+	return expanded, nil
+}
+
+// getWorkflowTags returns the tags in the workflow file at path on the repo's default branch.
+// Tags are cached per file, since several PRs may be handled for one comment.
+func (h *handler) getWorkflowTags(ctx context.Context, repoOwner, repoName, path string) ([]string, error) {
+	if tags, cached := h.workflowTags[path]; cached {
+		return tags, nil
+	}
+	file, _, resp, err := h.Repositories.GetContents(ctx, repoOwner, repoName, path, nil)
+	var tags []string
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusNotFound:
+		// The workflow's file was deleted, or only exists on another branch.
+		h.Debugf("Workflow file %s not found", path)
+	case err != nil:
+		return nil, err
+	default:
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, fmt.Errorf("decode %s: %v", path, err)
+		}
+		tags = parseWorkflowTags(content, h.workflowTagKey)
+	}
+	if h.workflowTags == nil {
+		h.workflowTags = make(map[string][]string)
+	}
+	h.workflowTags[path] = tags
+	return tags, nil
+}
+
+// parseWorkflowTags returns the comma-separated tags in comment lines of a workflow file's content starting with key,
+// ex. "# rerun-tag: smoke, e2e" for key "rerun-tag".
+func parseWorkflowTags(content, key string) (tags []string) {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		if !strings.HasPrefix(line, key+":") {
+			continue
+		}
+		for _, tag := range strings.Split(strings.TrimPrefix(line, key+":"), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
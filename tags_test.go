@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v33/github"
+)
+
+func TestParseWorkflowTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "none", content: "name: build\non: pull_request\n"},
+		{name: "one", content: "# rerun-tag: smoke\nname: build\n", want: []string{"smoke"}},
+		{name: "several", content: "name: build\n  #rerun-tag: smoke, e2e,\n# rerun-tag: nightly\n", want: []string{"smoke", "e2e", "nightly"}},
+		{name: "other key", content: "# rerun-group: smoke\n"},
+		{name: "not a comment", content: "env:\n  rerun-tag: smoke\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseWorkflowTags(tt.content, defaultWorkflowTagKey); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got tags %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandWorkflowTags(t *testing.T) {
+	workflows := []*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "e2e"), testWorkflow(3, "deleted")}
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/contents/.github/workflows/build.yaml", http.StatusOK,
+		&github.RepositoryContent{Content: github.String("name: build\n")})
+	api.handle(http.MethodGet, "/repos/o/r/contents/.github/workflows/e2e.yaml", http.StatusOK,
+		&github.RepositoryContent{Content: github.String("# rerun-tag: smoke\nname: e2e\n")})
+	h := newTestHandler(t, api)
+
+	for i := 0; i < 2; i++ {
+		expanded, err := h.expandWorkflowTags(context.Background(), testOwner, testRepo,
+			map[string]rerunOptions{testTagPrefix + "smoke": {failedJobsOnly: true}, "build": {}}, workflows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]rerunOptions{"e2e": {failedJobsOnly: true}, "build": {}}; !reflect.DeepEqual(expanded, want) {
+			t.Errorf("expansion %d: got %v, want %v", i, expanded, want)
+		}
+	}
+	// Workflow files are fetched once, including those not found.
+	for _, workflow := range workflows {
+		if calls := api.calls(http.MethodGet, "/repos/o/r/contents/"+workflow.GetPath()); calls != 1 {
+			t.Errorf("got %d requests for %s, want 1", calls, workflow.GetPath())
+		}
+	}
+}
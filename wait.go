@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+)
+
+const (
+	// defaultWaitTimeout is the default bound on waiting for reruns to complete.
+	defaultWaitTimeout = 30 * time.Minute
+	// How runs that have not completed are handled when they cannot be cancelled.
+	incompleteRunsSkip = "skip"
+	incompleteRunsWait = "wait"
+)
+
+// waitPollInterval is how often rerun status is polled. Polling starts after one interval
+// so a rerun's previous, completed attempt is not mistaken for the rerun. It is a variable so tests need not wait.
+var waitPollInterval = 30 * time.Second
+
+// waitForReruns polls started runs in results until all complete or h.waitTimeout elapses,
+// recording each run's conclusion.
+func (h *handler) waitForReruns(ctx context.Context, repoOwner, repoName string, results []rerunResult) error {
+	ctx, cancel := context.WithTimeout(ctx, h.waitTimeout)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(waitPollInterval):
+		}
+		pending := 0
+		for i := range results {
+			if !results[i].started() || results[i].conclusion != "" {
+				continue
+			}
+			run, _, err := h.Actions.GetWorkflowRunByID(ctx, repoOwner, repoName, results[i].run.GetID())
+			if err != nil {
+				return fmt.Errorf("get workflow run: %v", err)
+			}
+			results[i].run = run
+			if run.GetStatus() == completedStatus {
+				h.Debugf("Workflow run %d completed: %s", run.GetID(), run.GetConclusion())
+				results[i].conclusion = run.GetConclusion()
+				if h.failedJobLinks && run.GetConclusion() != successfulConclusion {
+					if results[i].failedJobURL, err = h.getFailedJobURL(ctx, repoOwner, repoName, run.GetID()); err != nil {
+						h.Debugf("Failed to get workflow run %d failed job: %v", run.GetID(), err)
+					}
+				}
+			} else {
+				pending++
+			}
+		}
+		if pending == 0 {
+			return nil
+		}
+		h.Debugf("Waiting for %d workflow runs to complete", pending)
+	}
+}
+
+// getFailedJobURL returns the URL of the first failed job of the latest attempt of the run with runID,
+// or an empty string if no job failed, ex. because the run was cancelled.
+func (h *handler) getFailedJobURL(ctx context.Context, repoOwner, repoName string, runID int64) (string, error) {
+	opts := &github.ListWorkflowJobsOptions{Filter: "latest"}
+	for {
+		jobs, resp, err := h.Actions.ListWorkflowJobs(ctx, repoOwner, repoName, runID, opts)
+		if err != nil {
+			return "", fmt.Errorf("list workflow run jobs: %v", err)
+		}
+		for _, job := range jobs.Jobs {
+			if job.GetConclusion() == failureConclusion {
+				return job.GetHTMLURL(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return "", nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// waitForRun polls the run with runID until it completes or deadline passes, returning the completed run.
+func (h *handler) waitForRun(ctx context.Context, repoOwner, repoName string, runID int64, deadline time.Time) (*github.WorkflowRun, error) {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	for {
+		run, _, err := h.Actions.GetWorkflowRunByID(ctx, repoOwner, repoName, runID)
+		if err != nil {
+			return nil, fmt.Errorf("get workflow run: %v", err)
+		}
+		if run.GetStatus() == completedStatus {
+			return run, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(waitPollInterval):
+		}
+	}
+}
+
+// awaitCancelGrace waits until graceEnd, then gets the run with runID so it is handled by its status at that time.
+func (h *handler) awaitCancelGrace(ctx context.Context, repoOwner, repoName string, runID int64, graceEnd time.Time) (*github.WorkflowRun, error) {
+	if wait := time.Until(graceEnd); wait > 0 {
+		h.Debugf("Waiting %s before cancelling workflow run %d", wait.Round(time.Second), runID)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	run, _, err := h.Actions.GetWorkflowRunByID(ctx, repoOwner, repoName, runID)
+	if err != nil {
+		return nil, fmt.Errorf("get workflow run: %v", err)
+	}
+	return run, nil
+}
+
+// mergeIfGreen merges pr if every run in results for pr either already succeeded or was rerun and succeeded.
+func (h *handler) mergeIfGreen(ctx context.Context, repoOwner, repoName string, pr *github.PullRequest, results []rerunResult) error {
+	numRuns := 0
+	for _, result := range results {
+		if result.prNum != pr.GetNumber() {
+			continue
+		}
+		numRuns++
+		if result.outcome == outcomeSkippedSucceeded || (result.started() && result.conclusion == successfulConclusion) {
+			continue
+		}
+		h.Debugf("Workflow %s run %d is not green (%s), will not merge", result.workflowName, result.run.GetID(), result.result())
+		return nil
+	}
+	if numRuns == 0 {
+		h.Debugf("No workflow runs found, will not merge")
+		return nil
+	}
+	// Only merge the head that was tested.
+	opts := &github.PullRequestOptions{SHA: pr.GetHead().GetSHA()}
+	mergeResult, _, err := h.PullRequests.Merge(ctx, repoOwner, repoName, pr.GetNumber(), "", opts)
+	if err != nil {
+		return err
+	}
+	h.Debugf("Merged PR %d: %s", pr.GetNumber(), mergeResult.GetMessage())
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v33/github"
+)
+
+// testIncompleteRun returns an in-progress pull_request run of the workflow with workflowID.
+func testIncompleteRun(id, workflowID int64) *github.WorkflowRun {
+	run := testRun(id, workflowID, testHeadSHA, "")
+	run.Status = github.String("in_progress")
+	run.Conclusion = nil
+	return run
+}
+
+func TestRerunPRWorkflowsIncomplete(t *testing.T) {
+	defer func(interval time.Duration) { waitPollInterval = interval }(waitPollInterval)
+	waitPollInterval = time.Millisecond
+	tests := []struct {
+		name           string
+		neverCancel    bool
+		waitIncomplete bool
+		want           string
+		wantCancel     bool
+		wantRerun      bool
+	}{
+		{name: "cancelled", want: outcomeRerun, wantCancel: true, wantRerun: true},
+		{name: "never cancel", neverCancel: true, want: outcomeSkippedIncomplete},
+		{name: "never cancel wait", neverCancel: true, waitIncomplete: true, want: outcomeRerun, wantRerun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testIncompleteRun(10, 1)}})
+			api.handleOnce(http.MethodGet, "/repos/o/r/actions/runs/10", http.StatusOK, testIncompleteRun(10, 1))
+			api.handle(http.MethodGet, "/repos/o/r/actions/runs/10", http.StatusOK, testRun(10, 1, testHeadSHA, failureConclusion))
+			api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/cancel", http.StatusAccepted, nil)
+			api.handleReruns(10)
+			h := newTestHandler(t, api)
+			h.neverCancel, h.waitIncomplete = tt.neverCancel, tt.waitIncomplete
+
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{"build": {}}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := outcomes(results)["build"]; got != tt.want {
+				t.Errorf("got outcome %q, want %q", got, tt.want)
+			}
+			if cancel := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/cancel"); cancel != tt.wantCancel {
+				t.Errorf("got cancel %t, want %t", cancel, tt.wantCancel)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != tt.wantRerun {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantRerun)
+			}
+		})
+	}
+}
+
+func TestRerunPRWorkflowsWaitIncompleteDeadline(t *testing.T) {
+	defer func(interval time.Duration) { waitPollInterval = interval }(waitPollInterval)
+	waitPollInterval = time.Millisecond
+	workflows := []*github.Workflow{testWorkflow(1, "build"), testWorkflow(2, "lint")}
+	runs := map[int64][]*github.WorkflowRun{1: {testIncompleteRun(10, 1)}, 2: {testIncompleteRun(20, 2)}}
+	api := newFakeAPI(t)
+	api.handleWorkflows(workflows, runs)
+	for id, wfRuns := range runs {
+		api.handle(http.MethodGet, fmt.Sprintf("/repos/o/r/actions/runs/%d", id*10), http.StatusOK, wfRuns[0])
+	}
+	h := newTestHandler(t, api)
+	h.neverCancel, h.waitIncomplete = true, true
+	h.rerunOrder = rerunOrderName
+	h.waitTimeout = 50 * time.Millisecond
+
+	results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{testAll: {}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"build": outcomeSkippedIncomplete, "lint": outcomeSkippedIncomplete}
+	if got := outcomes(results); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got outcomes %v, want %v", got, want)
+	}
+	// build is waited for until the deadline, which lint's wait shares, so lint's run is not polled again.
+	if calls := api.calls(http.MethodGet, "/repos/o/r/actions/runs/20"); calls > 1 {
+		t.Errorf("got %d polls of lint's run after the deadline, want at most 1", calls)
+	}
+}
+
+func TestMergeIfGreen(t *testing.T) {
+	green := func(id int64) rerunResult {
+		return rerunResult{prNum: testPRNum, workflowName: "build", run: testRun(id, 1, testHeadSHA, successfulConclusion),
+			outcome: outcomeRerun, conclusion: successfulConclusion}
+	}
+	tests := []struct {
+		name      string
+		results   []rerunResult
+		wantMerge bool
+	}{
+		{name: "all green", results: []rerunResult{green(10), {prNum: testPRNum, outcome: outcomeSkippedSucceeded, run: testRun(20, 2, testHeadSHA, successfulConclusion)}}, wantMerge: true},
+		{name: "one red", results: []rerunResult{green(10), {prNum: testPRNum, workflowName: "lint", outcome: outcomeRerun, conclusion: failureConclusion, run: testRun(20, 2, testHeadSHA, failureConclusion)}}},
+		{name: "rerun incomplete", results: []rerunResult{green(10), {prNum: testPRNum, workflowName: "lint", outcome: outcomeRerun, run: testRun(20, 2, testHeadSHA, "")}}},
+		{name: "rerun failed", results: []rerunResult{{prNum: testPRNum, workflowName: "build", outcome: outcomeRerunFailed, run: testRun(10, 1, testHeadSHA, failureConclusion)}}},
+		{name: "no runs", results: []rerunResult{{prNum: 2, outcome: outcomeSkippedSucceeded, run: testRun(10, 1, testHeadSHA, successfulConclusion)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handle(http.MethodPut, "/repos/o/r/pulls/1/merge", http.StatusOK, &github.PullRequestMergeResult{Merged: github.Bool(true)})
+			h := newTestHandler(t, api)
+
+			if err := h.mergeIfGreen(context.Background(), testOwner, testRepo, testPR(), tt.results); err != nil {
+				t.Fatal(err)
+			}
+			if merged := api.called(http.MethodPut, "/repos/o/r/pulls/1/merge"); merged != tt.wantMerge {
+				t.Errorf("got merged %t, want %t", merged, tt.wantMerge)
+			}
+			if tt.wantMerge {
+				var opts struct {
+					SHA string `json:"sha"`
+				}
+				api.body(t, http.MethodPut, "/repos/o/r/pulls/1/merge", &opts)
+				if opts.SHA != testHeadSHA {
+					t.Errorf("got merge SHA %q, want the tested head %q", opts.SHA, testHeadSHA)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForReruns(t *testing.T) {
+	defer func(interval time.Duration) { waitPollInterval = interval }(waitPollInterval)
+	waitPollInterval = time.Millisecond
+	rerun := func(id int64) rerunResult {
+		return rerunResult{prNum: testPRNum, run: testRun(id, id/10, testHeadSHA, failureConclusion), outcome: outcomeRerun}
+	}
+
+	api := newFakeAPI(t)
+	api.handleOnce(http.MethodGet, "/repos/o/r/actions/runs/10", http.StatusOK, testIncompleteRun(10, 1))
+	api.handle(http.MethodGet, "/repos/o/r/actions/runs/10", http.StatusOK, testRun(10, 1, testHeadSHA, successfulConclusion))
+	api.handle(http.MethodGet, "/repos/o/r/actions/runs/20", http.StatusOK, testRun(20, 2, testHeadSHA, failureConclusion))
+	h := newTestHandler(t, api)
+	results := []rerunResult{rerun(10), rerun(20), {prNum: testPRNum, run: testRun(30, 3, testHeadSHA, successfulConclusion), outcome: outcomeSkippedSucceeded}}
+	if err := h.waitForReruns(context.Background(), testOwner, testRepo, results); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].conclusion != successfulConclusion || results[1].conclusion != failureConclusion || results[2].conclusion != "" {
+		t.Errorf("got conclusions %q, %q, %q, want success, failure, and none", results[0].conclusion, results[1].conclusion, results[2].conclusion)
+	}
+	if api.called(http.MethodGet, "/repos/o/r/actions/runs/30") {
+		t.Errorf("run that was not rerun was polled")
+	}
+
+	// Runs that never complete are waited for until the timeout.
+	api = newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/actions/runs/10", http.StatusOK, testIncompleteRun(10, 1))
+	h = newTestHandler(t, api)
+	h.waitTimeout = 20 * time.Millisecond
+	results = []rerunResult{rerun(10)}
+	if err := h.waitForReruns(context.Background(), testOwner, testRepo, results); err == nil {
+		t.Errorf("got no error, want a timeout")
+	}
+	if results[0].conclusion != "" {
+		t.Errorf("got conclusion %q, want none", results[0].conclusion)
+	}
+}
+
+func TestRerunPRWorkflowsCancelGrace(t *testing.T) {
+	tests := []struct {
+		name       string
+		fresh      *github.WorkflowRun
+		want       string
+		wantCancel bool
+		wantRerun  bool
+	}{
+		{name: "still running", fresh: testIncompleteRun(10, 1), want: outcomeRerun, wantCancel: true, wantRerun: true},
+		{name: "failed", fresh: testRun(10, 1, testHeadSHA, failureConclusion), want: outcomeRerun, wantRerun: true},
+		{name: "succeeded", fresh: testRun(10, 1, testHeadSHA, successfulConclusion), want: outcomeSkippedSucceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.handleWorkflows([]*github.Workflow{testWorkflow(1, "build")},
+				map[int64][]*github.WorkflowRun{1: {testIncompleteRun(10, 1)}})
+			api.handle(http.MethodGet, "/repos/o/r/actions/runs/10", http.StatusOK, tt.fresh)
+			api.handle(http.MethodPost, "/repos/o/r/actions/runs/10/cancel", http.StatusAccepted, nil)
+			api.handleReruns(10)
+			h := newTestHandler(t, api)
+			h.cancelGrace = 10 * time.Millisecond
+
+			start := time.Now()
+			results, err := h.rerunPRWorkflows(context.Background(), testOwner, testRepo, testPR(), map[string]rerunOptions{"build": {}}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if waited := time.Since(start); waited < h.cancelGrace {
+				t.Errorf("got wait %s, want at least %s", waited, h.cancelGrace)
+			}
+			if got := outcomes(results)["build"]; got != tt.want {
+				t.Errorf("got outcome %q, want %q", got, tt.want)
+			}
+			if cancel := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/cancel"); cancel != tt.wantCancel {
+				t.Errorf("got cancel %t, want %t", cancel, tt.wantCancel)
+			}
+			if rerun := api.called(http.MethodPost, "/repos/o/r/actions/runs/10/rerun"); rerun != tt.wantRerun {
+				t.Errorf("got rerun %t, want %t", rerun, tt.wantRerun)
+			}
+		})
+	}
+}
+
+func TestWaitForRerunsFailedJobLinks(t *testing.T) {
+	defer func(interval time.Duration) { waitPollInterval = interval }(waitPollInterval)
+	waitPollInterval = time.Millisecond
+	const jobURL = "https://github.com/o/r/runs/101"
+	failed, succeeded := testRun(10, 1, testHeadSHA, failureConclusion), testRun(20, 2, testHeadSHA, successfulConclusion)
+	failed.HTMLURL = github.String("https://github.com/o/r/actions/runs/10")
+	api := newFakeAPI(t)
+	api.handle(http.MethodGet, "/repos/o/r/actions/runs/10", http.StatusOK, failed)
+	api.handle(http.MethodGet, "/repos/o/r/actions/runs/20", http.StatusOK, succeeded)
+	api.handle(http.MethodGet, "/repos/o/r/actions/runs/10/jobs", http.StatusOK, &github.Jobs{Jobs: []*github.WorkflowJob{
+		{Conclusion: github.String(successfulConclusion), HTMLURL: github.String("https://github.com/o/r/runs/100")},
+		{Conclusion: github.String(failureConclusion), HTMLURL: github.String(jobURL)},
+	}})
+	h := newTestHandler(t, api)
+	h.failedJobLinks = true
+	results := []rerunResult{
+		{prNum: testPRNum, workflowName: "build", run: testRun(10, 1, testHeadSHA, failureConclusion), outcome: outcomeRerun},
+		{prNum: testPRNum, workflowName: "lint", run: testRun(20, 2, testHeadSHA, failureConclusion), outcome: outcomeRerun},
+	}
+
+	if err := h.waitForReruns(context.Background(), testOwner, testRepo, results); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].failedJobURL != jobURL || results[1].failedJobURL != "" {
+		t.Errorf("got failed job URLs %q and %q, want %q and none", results[0].failedJobURL, results[1].failedJobURL, jobURL)
+	}
+	if api.called(http.MethodGet, "/repos/o/r/actions/runs/20/jobs") {
+		t.Errorf("jobs of a succeeded rerun were listed")
+	}
+	for _, req := range api.requests {
+		if req.URL.Path == "/repos/o/r/actions/runs/10/jobs" && req.URL.Query().Get("filter") != "latest" {
+			t.Errorf("got jobs filter %q, want latest", req.URL.Query().Get("filter"))
+		}
+	}
+	formatted := summary{results: results}.formatResults()
+	for _, link := range []string{"(" + failed.GetHTMLURL() + ")", "([failed job](" + jobURL + "))"} {
+		if !strings.Contains(formatted, link) {
+			t.Errorf("got summary %q, want it to contain %q", formatted, link)
+		}
+	}
+}